@@ -0,0 +1,238 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package analyzer provides an analysis.Analyzer reporting problems of evon
+// annotations and stale generated code, for go vet, gopls and multicheckers.
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lych77/evon"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+// Analyzer reports invalid evon annotations, and generated files not matching them.
+var Analyzer = &analysis.Analyzer{
+	Name: "evon",
+	Doc: `check evon annotations and generated dispatchers
+
+Reports invalid flags and flag combinations, misplaced annotations, handler
+type names without the required suffix, annotated types other than func or
+interface ones, and generated files that are out of date or should have been
+removed. The flags should match the ones the evon command is run with.`,
+	Run: run,
+}
+
+var (
+	flagHandlerSuffix string
+	flagEventSuffix   string
+	flagOut           string
+	flagRecordOut     string
+	flagTemplate      string
+	flagGroup         string
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&flagHandlerSuffix, "handler_suffix", "Handler", "Required suffix of the event handler type names")
+	Analyzer.Flags.StringVar(&flagEventSuffix, "event_suffix", "Event", "Suffix of the generated event type names")
+	Analyzer.Flags.StringVar(&flagOut, "out", "evon_gen.go", "Output source file name")
+	Analyzer.Flags.StringVar(&flagRecordOut, "record_out", "", "Output source file name for recorders (default the same as -out)")
+	Analyzer.Flags.StringVar(&flagTemplate, "template", "", "User template file rendered after every generated dispatcher")
+	Analyzer.Flags.StringVar(&flagGroup, "group", "Group", `Name of the package-level type generated for the "owner" flag`)
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	// The evon command never sees test files, except generated recorders
+	files := []*ast.File{}
+	for _, f := range pass.Files {
+		if !strings.HasSuffix(pass.Fset.File(f.Pos()).Name(), "_test.go") {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	pkg := &packages.Package{
+		ID:        pass.Pkg.Path(),
+		Name:      pass.Pkg.Name(),
+		PkgPath:   pass.Pkg.Path(),
+		Fset:      pass.Fset,
+		Syntax:    files,
+		Types:     pass.Pkg,
+		TypesInfo: pass.TypesInfo,
+	}
+
+	opts := &evon.Options{
+		HandlerSuffix:     flagHandlerSuffix,
+		EventSuffix:       flagEventSuffix,
+		SeparateRecorders: flagRecordOut != "",
+		GroupName:         flagGroup,
+	}
+	if flagTemplate != "" {
+		src, err := os.ReadFile(flagTemplate)
+		if err != nil {
+			return nil, err
+		}
+		opts.Template = string(src)
+	}
+
+	model, err := evon.Parse(pkg, opts)
+	if errs, ok := err.(evon.ErrorList); ok {
+		for _, e := range errs {
+			reportError(pass, e)
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(pass.Fset.File(files[0].Pos()).Name())
+	path := filepath.Join(dir, flagOut)
+	recPath := filepath.Join(dir, flagRecordOut)
+
+	if len(model.Decls) == 0 {
+		checkGenerated(pass, files[0].Package, path, nil)
+		if flagRecordOut != "" {
+			checkGenerated(pass, files[0].Package, recPath, nil)
+		}
+		return nil, nil
+	}
+
+	pos := model.Decls[0].Ann.Pos
+
+	src, err := evon.Generate(model, opts)
+	if err != nil {
+		reportError(pass, err)
+		return nil, nil
+	}
+	checkGenerated(pass, pos, path, src)
+
+	if flagRecordOut != "" {
+		recSrc, err := evon.GenerateRecorders(model, opts)
+		if err != nil {
+			reportError(pass, err)
+			return nil, nil
+		}
+		checkGenerated(pass, pos, recPath, recSrc)
+	}
+
+	return nil, nil
+}
+
+func listed(pass *analysis.Pass, path string) bool {
+	for _, files := range [][]string{pass.OtherFiles, pass.IgnoredFiles} {
+		for _, f := range files {
+			if f == path {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func reportError(pass *analysis.Pass, err error) {
+	e, ok := err.(*evon.Error)
+	if !ok {
+		pass.Reportf(pass.Files[0].Package, "%s", err)
+		return
+	}
+
+	diag := analysis.Diagnostic{Pos: e.Pos, Message: e.Msg}
+	if e.Fix != nil {
+		diag.SuggestedFixes = []analysis.SuggestedFix{{
+			Message:   e.Fix.Message,
+			TextEdits: []analysis.TextEdit{{Pos: e.Fix.Pos, End: e.Fix.End, NewText: []byte(e.Fix.NewText)}},
+		}}
+	}
+	pass.Report(diag)
+}
+
+// checkGenerated reports a generated file not matching src, with a fix replacing
+// its content when it is part of the package. A nil src means the file should
+// not exist.
+func checkGenerated(pass *analysis.Pass, pos token.Pos, path string, src []byte) {
+	var file *token.File
+	hasTests := false
+	for _, f := range pass.Files {
+		tf := pass.Fset.File(f.Pos())
+		if tf.Name() == path {
+			file = tf
+			pos = f.Package
+		}
+		hasTests = hasTests || strings.HasSuffix(tf.Name(), "_test.go")
+	}
+
+	// Files out of the package don't exist as far as the analysis can tell,
+	// except test files when analyzing the package without its tests, which
+	// are left to the pass with them
+	if file == nil && !listed(pass, path) {
+		if src != nil && (hasTests || !strings.HasSuffix(path, "_test.go")) {
+			pass.Reportf(pos, "Generated file %s is missing, run evon to generate it", filepath.Base(path))
+		}
+		return
+	}
+
+	old, err := pass.ReadFile(path)
+	if err != nil {
+		pass.Reportf(pos, "Cannot read generated file: %s", err)
+		return
+	}
+
+	if src == nil {
+		pass.Reportf(pos, "Generated file %s is stale and should be removed", filepath.Base(path))
+		return
+	}
+	if bytes.Equal(old, src) {
+		return
+	}
+
+	diag := analysis.Diagnostic{
+		Pos:     pos,
+		Message: fmt.Sprintf("Generated file %s is out of date, run evon to regenerate it", filepath.Base(path)),
+	}
+	if file != nil && file.Size() == len(old) {
+		diag.SuggestedFixes = []analysis.SuggestedFix{{
+			Message: fmt.Sprintf("Regenerate %s", filepath.Base(path)),
+			TextEdits: []analysis.TextEdit{{
+				Pos:     token.Pos(file.Base()),
+				End:     token.Pos(file.Base() + file.Size()),
+				NewText: src,
+			}},
+		}}
+	}
+	pass.Report(diag)
+}
@@ -26,60 +26,18 @@
 // OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
-package main
+package analyzer
 
 import (
-	"go/ast"
-	"go/types"
+	"testing"
 
-	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/analysis/analysistest"
 )
 
-type typeResolver map[*packages.Package]map[types.Object]*ast.Ident
-
-func (reso typeResolver) Resolve(pkg *packages.Package, typ ast.Expr) (*packages.Package, ast.Expr) {
-	switch typImpl := typ.(type) {
-	case *ast.Ident:
-		if typImpl.Obj != nil {
-			return reso.Resolve(pkg, typImpl.Obj.Decl.(*ast.TypeSpec).Type)
-		}
-
-		target, ok := pkg.TypesInfo.Uses[typImpl]
-		if !ok {
-			return nil, nil
-		}
-
-		nextPkg := pkg
-		if target.Pkg() != pkg.Types {
-			nextPkg = pkg.Imports[target.Pkg().Path()]
-		}
-
-		nextIdent, ok := reso.identMap(nextPkg, pkg)[target]
-		if !ok {
-			return nil, nil
-		}
-
-		return reso.Resolve(nextPkg, nextIdent)
-	case *ast.SelectorExpr:
-		return reso.Resolve(pkg, typImpl.Sel)
-	case *ast.ParenExpr:
-		return reso.Resolve(pkg, typImpl.X)
-	default:
-		return pkg, typ
-	}
+func TestDiagnostics(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "missing", "stale")
 }
 
-func (reso typeResolver) identMap(pkg, base *packages.Package) map[types.Object]*ast.Ident {
-	if res, ok := reso[pkg]; ok {
-		return res
-	}
-
-	res := make(map[types.Object]*ast.Ident)
-	for i, o := range pkg.TypesInfo.Defs {
-		if o != nil && o.Parent() == pkg.Types.Scope() && (o.Exported() || pkg == base) {
-			res[o] = i
-		}
-	}
-	reso[pkg] = res
-	return res
+func TestSuggestedFixes(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "flags", "outdated")
 }
@@ -0,0 +1,7 @@
+package flags
+
+// @evon(lcok) // want `Invalid flag "lcok"`
+type LoginHandler func(uid int)
+
+// @evon(lock, bogus, unsub) // want `Invalid flag "bogus"`
+type LogoutHandler func(uid int)
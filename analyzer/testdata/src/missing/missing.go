@@ -0,0 +1,4 @@
+package missing
+
+// @evon(lock) // want `Generated file evon_gen.go is missing, run evon to generate it`
+type LoginHandler func(uid int)
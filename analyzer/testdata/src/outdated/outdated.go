@@ -0,0 +1,4 @@
+package outdated
+
+// @evon(lock)
+type LoginHandler func(uid int)
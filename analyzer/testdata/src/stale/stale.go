@@ -0,0 +1,3 @@
+package stale
+
+type LoginHandler func(uid int)
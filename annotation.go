@@ -0,0 +1,301 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Annotation is an "@evon(...)" annotation with the flags in it.
+type Annotation struct {
+	Pos   token.Pos
+	Flags map[string]bool
+	// Template is the name of the user template given by the "template=name" option
+	Template string
+}
+
+// FormatFlags formats the flags sorted, like "(lock, unsub)".
+func (ann *Annotation) FormatFlags() string {
+	res := []string{}
+	for f := range ann.Flags {
+		res = append(res, f)
+	}
+	if ann.Template != "" {
+		res = append(res, optTemplate+"="+ann.Template)
+	}
+	sort.Strings(res)
+	return "(" + strings.Join(res, ", ") + ")"
+}
+
+var annRe = regexp.MustCompile(`@evon\(\s*(.*?)\s*\)`)
+
+const (
+	annBalance = "balance"
+	annBase    = "base"
+	annCall    = "call"
+	annCatch   = "catch"
+	annChan    = "chan"
+	annContext = "context"
+	annDedup   = "dedup"
+	annFuncs   = "funcs"
+	annGuard   = "guard"
+	annHandle  = "handle"
+	annHash    = "hash"
+	annLazy    = "lazy"
+	annLimit   = "limit"
+	annLock    = "lock"
+	annMsg     = "msg"
+	annOwner   = "owner"
+	annPause   = "pause"
+	annQueue   = "queue"
+	annRecord  = "record"
+	annReduce  = "reduce"
+	annRoute   = "route"
+	annSpawn   = "spawn"
+	annTopics  = "topics"
+	annUnusb   = "unsub"
+	annWait    = "wait"
+
+	annSep = ","
+
+	optTemplate = "template"
+	optSep      = "="
+)
+
+var validFlags = map[string]bool{
+	annBalance: true,
+	annBase:    true,
+	annCall:    true,
+	annCatch:   true,
+	annChan:    true,
+	annContext: true,
+	annDedup:   true,
+	annFuncs:   true,
+	annGuard:   true,
+	annHandle:  true,
+	annHash:    true,
+	annLazy:    true,
+	annLimit:   true,
+	annLock:    true,
+	annMsg:     true,
+	annOwner:   true,
+	annPause:   true,
+	annQueue:   true,
+	annRecord:  true,
+	annReduce:  true,
+	annRoute:   true,
+	annSpawn:   true,
+	annTopics:  true,
+	annUnusb:   true,
+	annWait:    true,
+}
+
+func extractAnnotation(cg *ast.CommentGroup, fset *token.FileSet) (*Annotation, error) {
+	ann := &Annotation{Flags: make(map[string]bool)}
+
+	foundCmt := (*ast.Comment)(nil)
+	foundOffsets := []int(nil)
+
+	for _, cmt := range cg.List {
+		matches := annRe.FindAllStringSubmatchIndex(cmt.Text, 2)
+
+		if matches == nil {
+			continue
+		}
+
+		if foundCmt != nil {
+			nextPos := fset.Position(cmt.Pos() + token.Pos(matches[0][0]))
+			nextPos.Filename = ""
+			return nil, errorf(fset, ann.Pos, "Redundant annotation at %s", nextPos)
+		}
+
+		foundCmt = cmt
+		foundOffsets = matches[0]
+		ann.Pos = foundCmt.Pos() + token.Pos(foundOffsets[0])
+
+		if len(matches) > 1 {
+			nextPos := fset.Position(cmt.Pos() + token.Pos(matches[1][0]))
+			nextPos.Filename = ""
+			return nil, errorf(fset, ann.Pos, "Redundant annotation at %s", nextPos)
+		}
+	}
+
+	if foundCmt == nil {
+		return nil, nil
+	}
+
+	text := foundCmt.Text[foundOffsets[2]:foundOffsets[3]]
+	textPos := foundCmt.Pos() + token.Pos(foundOffsets[2])
+	offset := 0
+
+	for _, part := range strings.Split(text, annSep) {
+		partOffset := offset
+		offset += len(part) + len(annSep)
+
+		flag := strings.TrimSpace(part)
+
+		if len(flag) == 0 {
+			continue
+		}
+
+		if i := strings.Index(flag, optSep); i >= 0 {
+			key, val := strings.TrimSpace(flag[:i]), strings.TrimSpace(flag[i+1:])
+			if key != optTemplate || val == "" {
+				return nil, errorf(fset, ann.Pos, `Invalid option "%s"`, flag)
+			}
+			ann.Template = val
+			continue
+		}
+
+		if !validFlags[flag] {
+			err := errorf(fset, ann.Pos, `Invalid flag "%s"`, flag)
+			err.Fix = invalidFlagFix(text, textPos, partOffset, part, flag)
+			return nil, err
+		}
+
+		ann.Flags[flag] = true
+	}
+
+	if ann.Flags[annSpawn] && ann.Flags[annQueue] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" cannot coexist with "%s"`, annSpawn, annQueue)
+	}
+
+	if ann.Flags[annWait] && !(ann.Flags[annSpawn] || ann.Flags[annQueue]) {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s" or "%s"`, annWait, annSpawn, annQueue)
+	}
+
+	if ann.Flags[annReduce] && (ann.Flags[annSpawn] || ann.Flags[annQueue]) && !ann.Flags[annWait] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s" when "%s" or "%s" is used`,
+			annReduce, annWait, annSpawn, annQueue)
+	}
+
+	if ann.Flags[annBalance] && ann.Flags[annRoute] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" cannot coexist with "%s"`, annBalance, annRoute)
+	}
+
+	if ann.Flags[annHash] && !ann.Flags[annBalance] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annHash, annBalance)
+	}
+
+	if ann.Flags[annContext] && !(ann.Flags[annUnusb] && ann.Flags[annLock]) {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s" and "%s"`, annContext, annUnusb, annLock)
+	}
+
+	if ann.Flags[annLazy] && !ann.Flags[annUnusb] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annLazy, annUnusb)
+	}
+
+	if ann.Flags[annOwner] && !ann.Flags[annUnusb] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annOwner, annUnusb)
+	}
+
+	if ann.Flags[annGuard] && !ann.Flags[annCatch] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annGuard, annCatch)
+	}
+
+	if ann.Flags[annHandle] && !ann.Flags[annUnusb] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annHandle, annUnusb)
+	}
+
+	if ann.Flags[annChan] && !ann.Flags[annUnusb] {
+		return nil, errorf(fset, ann.Pos, `Flag "%s" can only be used together with "%s"`, annChan, annUnusb)
+	}
+
+	return ann, nil
+}
+
+// invalidFlagFix suggests replacing an invalid flag with the most similar valid
+// one, or otherwise removing it together with a separator.
+func invalidFlagFix(text string, textPos token.Pos, offset int, part, flag string) *Fix {
+	start := offset + strings.Index(part, flag)
+	if similar := similarFlag(flag); similar != "" {
+		return &Fix{
+			Message: fmt.Sprintf(`Replace with "%s"`, similar),
+			Pos:     textPos + token.Pos(start),
+			End:     textPos + token.Pos(start+len(flag)),
+			NewText: similar,
+		}
+	}
+
+	from, to := offset, offset+len(part)
+	if to < len(text) {
+		from = start
+		to += len(annSep)
+		to += len(text[to:]) - len(strings.TrimLeft(text[to:], " \t"))
+	} else if from > 0 {
+		from -= len(annSep)
+	}
+	return &Fix{
+		Message: fmt.Sprintf(`Remove "%s"`, flag),
+		Pos:     textPos + token.Pos(from),
+		End:     textPos + token.Pos(to),
+	}
+}
+
+// similarFlag finds the valid flag most similar to a misspelled one, if any.
+func similarFlag(flag string) string {
+	best, bestDist := "", 3
+	for f := range validFlags {
+		dist := editDistance(flag, f)
+		if dist < bestDist && dist < len(f) || dist == bestDist && f < best {
+			best, bestDist = f, dist
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = prev[j-1]
+			if a[i-1] != b[j-1] {
+				cur[j]++
+			}
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
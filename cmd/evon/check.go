@@ -0,0 +1,227 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const diffContext = 3
+
+// diffLimit bounds the size of the LCS table, larger changes are shown as a
+// whole replacement.
+const diffLimit = 1 << 22
+
+// checkFile compares the generated source with the file at path, reporting
+// the differences as a unified diff. A nil src means the file should not exist.
+func checkFile(path string, src []byte) bool {
+	old, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if src == nil {
+			return true
+		}
+		old, err = nil, nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+		return false
+	}
+
+	if src != nil && bytes.Equal(old, src) {
+		return true
+	}
+
+	if src == nil {
+		fmt.Fprintf(os.Stderr, "[evon] %s: Stale, should be removed\n", path)
+	} else {
+		fmt.Fprintf(os.Stderr, "[evon] %s: Out of date\n", path)
+	}
+	fmt.Print(unifiedDiff(path, old, src))
+	return false
+}
+
+// removeFile removes a stale generated file, or only checks its absence with -check.
+func removeFile(path string) bool {
+	if *flagCheck {
+		return checkFile(path, nil)
+	}
+	os.Remove(path)
+	return true
+}
+
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+func unifiedDiff(path string, old, src []byte) string {
+	ops := diffLines(splitLines(old), splitLines(src))
+
+	// Line numbers before each op
+	oldPos := make([]int, len(ops)+1)
+	newPos := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldPos[i+1], newPos[i+1] = oldPos[i], newPos[i]
+		if op.Kind != '+' {
+			oldPos[i+1]++
+		}
+		if op.Kind != '-' {
+			newPos[i+1]++
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "--- %s\n+++ %s (generated)\n", path, path)
+
+	for start := 0; start < len(ops); {
+		first := start
+		for first < len(ops) && ops[first].Kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].Kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*diffContext {
+				break
+			}
+		}
+
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n",
+			hunkRange(oldPos[from], oldPos[to]), hunkRange(newPos[from], newPos[to]))
+		for _, op := range ops[from:to] {
+			buf.WriteByte(op.Kind)
+			buf.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = to
+	}
+
+	return buf.String()
+}
+
+func hunkRange(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("%d,0", from)
+	}
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
+
+func splitLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines computes a line diff by the longest common subsequence, after
+// trimming the common prefix and suffix, which usually leave little in between.
+func diffLines(a, b []string) []diffOp {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := []diffOp{}
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	if len(ma)*len(mb) > diffLimit {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i]})
+				i++
+				j++
+			case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
@@ -29,196 +29,42 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"go/ast"
-	"go/format"
-	"go/printer"
-	"go/token"
 	"os"
-	"sort"
-	"strings"
-	"text/template"
-)
-
-type genFile struct {
-	Package string
-	Imports []*genImport
-	Events  []*genEvent
-
-	HandlerSuffix string
-	EventSuffix   string
-
-	SyncAlias      string
-	SyncAliasLocal string
-}
-
-type genImport struct {
-	Alias string
-	Path  string
-}
-
-type genEvent struct {
-	Name     string
-	Flags    map[string]bool
-	FlagsLit string
-	Funcs    []*genFunc
-	Dedups   map[string]string
-}
-
-type genFunc struct {
-	Name       string
-	Sig        string
-	Args       string
-	HasResults bool
-}
-
-func generate(par *parser, path string) bool {
-	file := &genFile{
-		Package:       par.Pkg.Name,
-		HandlerSuffix: *flagHandlerSuffix,
-		EventSuffix:   *flagEventSuffix,
-	}
-
-	importList, pkgNameSet := dedupImports(par)
-
-	for _, decl := range par.Decls {
-		paramSet := newDedupSet()
-
-		gfs := []*genFunc{}
-		for _, f := range decl.Event.Funcs {
-			gf := &genFunc{Name: f.Name}
-			renderSignatureArgs(gf, f.Type, par.Pkg.Fset, paramSet)
-			gfs = append(gfs, gf)
-		}
-
-		ge := &genEvent{
-			Name:     decl.Event.Name.Name[:len(decl.Event.Name.Name)-len(*flagHandlerSuffix)],
-			Flags:    decl.Ann.Flags,
-			FlagsLit: decl.Ann.FormatFlags(),
-			Funcs:    gfs,
-			Dedups:   make(map[string]string),
-		}
-
-		pkgNameSet.Merge(paramSet)
-
-		for _, n := range localIdents {
-			ge.Dedups[n] = paramSet.Resolve(n)
-		}
-		file.Events = append(file.Events, ge)
-	}
-
-	for _, r := range importList {
-		gi := &genImport{Path: r.Path}
-		if r.Alias != r.Name {
-			gi.Alias = r.Alias
-		}
-		file.Imports = append(file.Imports, gi)
-	}
-
-	if rec, ok := par.Imports["sync"]; ok {
-		file.SyncAlias = rec.Alias
-		if rec.Local {
-			file.SyncAliasLocal = pkgNameSet.Resolve(file.SyncAlias)
-			if file.SyncAliasLocal != file.SyncAlias {
-				file.Imports = append(file.Imports, &genImport{Alias: file.SyncAliasLocal, Path: "sync"})
-			}
-		}
-	}
-
-	return writeFile(file, path)
-}
+	"path/filepath"
 
-func dedupImports(par *parser) ([]*importRec, dedupSet) {
-	recs := []*importRec{}
-	for _, r := range par.Imports {
-		recs = append(recs, r)
-	}
-	sort.Slice(recs, func(i, j int) bool {
-		iPrio := recs[i].Priority
-		jPrio := recs[j].Priority
-		return iPrio < jPrio || iPrio == jPrio && recs[i].Path < recs[j].Path
-	})
-
-	dedup := newDedupSet()
-	for _, r := range recs {
-		r.Alias = dedup.Resolve(r.Name)
-
-		for id := range r.TypeIdents {
-			id.Name = r.Alias + "." + id.Name
-		}
-		for id := range r.PkgIdents {
-			id.Name = r.Alias
-		}
-	}
+	"github.com/lych77/evon"
+)
 
-	return recs, dedup
-}
+func generate(model *evon.Model, path string) bool {
+	opts := options()
 
-func renderSignatureArgs(gf *genFunc, typ *ast.FuncType, fset *token.FileSet, allParamSet dedupSet) {
-	paramSet := newDedupSet("_")
-	for _, pg := range typ.Params.List {
-		for _, n := range pg.Names {
-			if n.Name != "_" {
-				paramSet[n.Name] = true
-			}
-		}
+	src, err := evon.Generate(model, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+		return false
 	}
 
-	args := []string{}
-
-	for _, pg := range typ.Params.List {
-		if len(pg.Names) == 0 {
-			pg.Names = append(pg.Names, ast.NewIdent("_"))
-		}
-
-		for _, n := range pg.Names {
-			if n.Name == "_" {
-				n.Name = paramSet.Resolve("_")
-			}
-
-			args = append(args, n.Name)
-			allParamSet[n.Name] = true
-		}
+	ok := writeFile(path, src)
+	if !ok && !*flagCheck || *flagRecordOut == "" {
+		return ok
 	}
 
-	gf.Args = strings.Join(args, ", ")
-	if len(typ.Params.List) > 0 {
-		if _, ok := typ.Params.List[len(typ.Params.List)-1].Type.(*ast.Ellipsis); ok {
-			gf.Args += "..."
-		}
+	recPath := filepath.Join(filepath.Dir(path), *flagRecordOut)
+	recSrc, err := evon.GenerateRecorders(model, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+		return false
 	}
-
-	if typ.Results != nil {
-		for _, pg := range typ.Results.List {
-			if len(pg.Names) == 0 {
-				pg.Names = append(pg.Names, ast.NewIdent("_"))
-			} else {
-				for _, n := range pg.Names {
-					n.Name = "_"
-				}
-			}
-		}
-		gf.HasResults = true
+	if recSrc == nil {
+		return removeFile(recPath) && ok
 	}
-
-	sigBuf := &bytes.Buffer{}
-	printer.Fprint(sigBuf, fset, typ)
-	gf.Sig = sigBuf.String()[4:]
+	return writeFile(recPath, recSrc) && ok
 }
 
-func writeFile(file *genFile, path string) bool {
-	tpl := template.Must(template.New("").Funcs(template.FuncMap{"prefix": prefixIdent}).Parse(templateText))
-
-	buf := &bytes.Buffer{}
-	err := tpl.Execute(buf, file)
-	if err != nil {
-		panic(err)
-	}
-
-	src, err := format.Source(buf.Bytes())
-	if err != nil {
-		panic(err)
+func writeFile(path string, src []byte) bool {
+	if *flagCheck {
+		return checkFile(path, src)
 	}
 
 	outFile, err := os.Create(path)
@@ -232,10 +78,3 @@ func writeFile(file *genFile, path string) bool {
 	fmt.Printf("Generated %s\n", path)
 	return true
 }
-
-func prefixIdent(p, s string) string {
-	if token.IsExported(s) {
-		return strings.Title(p) + strings.Title(s)
-	}
-	return strings.ToLower(p) + strings.Title(s)
-}
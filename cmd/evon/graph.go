@@ -0,0 +1,437 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const (
+	graphFormatDot  = "dot"
+	graphFormatJSON = "json"
+)
+
+type graphNode struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Pos  string `json:"pos"`
+}
+
+type graphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Method string `json:"method,omitempty"`
+	Pos    string `json:"pos"`
+
+	fromVar, toVar varKey
+}
+
+// varKey identifies the variable holding a dispatcher at a call site, by the
+// variable the expression starts from and the fields selected from it. The
+// zero value stands for anything else, like results of function calls.
+type varKey struct {
+	root types.Object
+	path string
+}
+
+type graph struct {
+	Nodes  []*graphNode   `json:"nodes"`
+	Edges  []*graphEdge   `json:"edges"`
+	Cycles [][]*graphEdge `json:"cycles"`
+
+	// Cycles through fields of possibly different instances of the same type
+	PossibleCycles [][]*graphEdge `json:"possibleCycles"`
+
+	fset  *token.FileSet
+	objs  map[types.Object]*graphNode
+	names map[string]int
+}
+
+func runGraph(args []string) bool {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	format := fs.String("format", graphFormatDot, `Output format, "dot" or "json"`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] graph [graph flags] [packages]\nGraph flags:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *format != graphFormatDot && *format != graphFormatJSON {
+		fmt.Fprintf(os.Stderr, "Fatal: Invalid format \"%s\"\n", *format)
+		return false
+	}
+
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	cfg := &packages.Config{
+		Mode:       loadMode,
+		BuildFlags: []string{"-tags=" + *flagTags},
+	}
+
+	pkgs, err := packages.Load(cfg, localPatterns(patterns)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+		return false
+	}
+
+	g := buildGraph(pkgs)
+
+	if *format == graphFormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.Encode(g)
+	} else {
+		g.writeDot(os.Stdout)
+	}
+
+	for _, c := range g.Cycles {
+		fmt.Fprintf(os.Stderr, "[evon] Chaining cycle detected:\n\t%s\n", cycleSteps(c))
+	}
+	for _, c := range g.PossibleCycles {
+		fmt.Fprintf(os.Stderr, "[evon] Possible chaining cycle through fields, if they are of the same instances:\n\t%s\n", cycleSteps(c))
+	}
+
+	return len(g.Cycles) == 0
+}
+
+func cycleSteps(cycle []*graphEdge) string {
+	steps := []string{}
+	for _, e := range cycle {
+		steps = append(steps, fmt.Sprintf("%s: %s -> %s", e.Pos, e.From, e.To))
+	}
+	return strings.Join(steps, "\n\t")
+}
+
+// buildGraph collects the chaining edges of the packages and their cycles.
+func buildGraph(pkgs []*packages.Package) *graph {
+	g := &graph{
+		Nodes:          []*graphNode{},
+		Edges:          []*graphEdge{},
+		Cycles:         [][]*graphEdge{},
+		PossibleCycles: [][]*graphEdge{},
+		objs:           make(map[types.Object]*graphNode),
+		names:          make(map[string]int),
+	}
+
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			fmt.Fprintf(os.Stderr, "[go] %s\n", e)
+		}
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		g.fset = pkg.Fset
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok {
+					g.inspectCall(pkg, call)
+				}
+				return true
+			})
+		}
+	}
+
+	g.findCycles()
+	return g
+}
+
+// inspectCall records an edge for calls shaped like X.SubXxx(..., Y.Emit) or
+// X.SubXxx(..., Y.Emit.Method), where both X and Y are evon dispatchers.
+func (g *graph) inspectCall(pkg *packages.Package, call *ast.CallExpr) {
+	fun, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !strings.HasPrefix(fun.Sel.Name, "Sub") || len(call.Args) == 0 {
+		return
+	}
+
+	arg, ok := unparen(call.Args[len(call.Args)-1]).(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+
+	method := ""
+	if arg.Sel.Name != "Emit" {
+		inner, ok := unparen(arg.X).(*ast.SelectorExpr)
+		if !ok || inner.Sel.Name != "Emit" {
+			return
+		}
+		method, arg = arg.Sel.Name, inner
+	}
+
+	// Both ends are resolved before any node is added, so that the graph only
+	// has dispatchers taking part in some edge
+	fromRef := dispatcherOf(pkg, fun.X)
+	toRef := dispatcherOf(pkg, arg.X)
+	if fromRef == nil || toRef == nil {
+		return
+	}
+	from, to := g.node(fromRef), g.node(toRef)
+
+	g.Edges = append(g.Edges, &graphEdge{
+		From:    from.ID,
+		To:      to.ID,
+		Method:  method,
+		Pos:     g.fset.Position(call.Pos()).String(),
+		fromVar: varKeyOf(pkg, fun.X),
+		toVar:   varKeyOf(pkg, arg.X),
+	})
+}
+
+// dispatcherRef is a variable or field holding an evon dispatcher.
+type dispatcherRef struct {
+	obj   types.Object
+	owner string
+	typ   types.Type
+}
+
+// dispatcherOf returns the variable or field referred to by expr if it holds
+// an evon dispatcher, or nil if the expression is anything else.
+func dispatcherOf(pkg *packages.Package, expr ast.Expr) *dispatcherRef {
+	expr = unparen(expr)
+
+	typ := pkg.TypesInfo.TypeOf(expr)
+	if typ == nil || !isDispatcher(typ) {
+		return nil
+	}
+
+	obj := types.Object(nil)
+	owner := ""
+	switch x := expr.(type) {
+	case *ast.Ident:
+		obj = pkg.TypesInfo.Uses[x]
+	case *ast.SelectorExpr:
+		if sel, ok := pkg.TypesInfo.Selections[x]; ok {
+			obj = sel.Obj()
+			owner = types.TypeString(derefType(sel.Recv()), types.RelativeTo(nil)) + "."
+		} else {
+			obj = pkg.TypesInfo.Uses[x.Sel]
+		}
+	}
+
+	if obj == nil || obj.Pkg() == nil {
+		return nil
+	}
+	if owner == "" {
+		owner = obj.Pkg().Path() + "."
+	}
+	return &dispatcherRef{obj: obj, owner: owner, typ: typ}
+}
+
+// varKeyOf returns the variable an expression like v, v.f or (*v).f.g refers to.
+func varKeyOf(pkg *packages.Package, expr ast.Expr) varKey {
+	path := ""
+	for {
+		switch x := unparen(expr).(type) {
+		case *ast.Ident:
+			if obj, ok := pkg.TypesInfo.Uses[x].(*types.Var); ok {
+				return varKey{root: obj, path: path}
+			}
+			return varKey{}
+		case *ast.SelectorExpr:
+			if _, ok := pkg.TypesInfo.Selections[x]; !ok {
+				// A qualified identifier referring to a package-level variable
+				expr = x.Sel
+				continue
+			}
+			path = "." + x.Sel.Name + path
+			expr = x.X
+		case *ast.StarExpr:
+			expr = x.X
+		default:
+			return varKey{}
+		}
+	}
+}
+
+// node returns the node of a dispatcher, adding it on first sight.
+func (g *graph) node(ref *dispatcherRef) *graphNode {
+	if n, ok := g.objs[ref.obj]; ok {
+		return n
+	}
+
+	id := ref.owner + ref.obj.Name()
+	g.names[id]++
+	if g.names[id] > 1 {
+		id = fmt.Sprintf("%s#%d", id, g.names[id])
+	}
+
+	n := &graphNode{
+		ID:   id,
+		Type: types.TypeString(ref.typ, types.RelativeTo(nil)),
+		Pos:  g.fset.Position(ref.obj.Pos()).String(),
+	}
+	g.objs[ref.obj] = n
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+// findCycles reports one cycle per back edge met by a depth first search.
+func (g *graph) findCycles() {
+	out := make(map[string][]*graphEdge)
+	for _, e := range g.Edges {
+		out[e.From] = append(out[e.From], e)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	stack := []*graphEdge{}
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		for _, e := range out[id] {
+			switch state[e.To] {
+			case unvisited:
+				stack = append(stack, e)
+				visit(e.To)
+				stack = stack[:len(stack)-1]
+			case visiting:
+				start := len(stack)
+				for i := len(stack) - 1; i >= 0 && e.From != e.To; i-- {
+					if stack[i].From == e.To {
+						start = i
+						break
+					}
+				}
+				cycle := append(append([]*graphEdge{}, stack[start:]...), e)
+				if sameVars(cycle) {
+					g.Cycles = append(g.Cycles, cycle)
+				} else {
+					g.PossibleCycles = append(g.PossibleCycles, cycle)
+				}
+			}
+		}
+		state[id] = visited
+	}
+
+	for _, n := range g.Nodes {
+		if state[n.ID] == unvisited {
+			visit(n.ID)
+		}
+	}
+}
+
+// sameVars tells whether every edge of a cycle starts from the very variable
+// the previous one leads to. Nodes of fields stand for the fields of all
+// instances, so a cycle through them can be made of different instances, like
+// a node subscribing to its parent, and it's only certain when the fields are
+// selected from the same variable.
+func sameVars(cycle []*graphEdge) bool {
+	for i, e := range cycle {
+		next := cycle[(i+1)%len(cycle)]
+		if e.toVar.root == nil || e.toVar != next.fromVar {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *graph) writeDot(w io.Writer) {
+	colors := make(map[*graphEdge]string)
+	for _, c := range g.PossibleCycles {
+		for _, e := range c {
+			colors[e] = "orange"
+		}
+	}
+	for _, c := range g.Cycles {
+		for _, e := range c {
+			colors[e] = "red"
+		}
+	}
+
+	nodes := append([]*graphNode{}, g.Nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	fmt.Fprintln(w, "digraph evon {")
+	for _, n := range nodes {
+		fmt.Fprintf(w, "\t%q [label=%q, tooltip=%q];\n", n.ID, n.ID+"\n"+n.Type, n.Pos)
+	}
+	for _, e := range g.Edges {
+		attrs := fmt.Sprintf("tooltip=%q", e.Pos)
+		if e.Method != "" {
+			attrs += fmt.Sprintf(", label=%q", e.Method)
+		}
+		if c := colors[e]; c != "" {
+			attrs += ", color=" + c
+		}
+		fmt.Fprintf(w, "\t%q -> %q [%s];\n", e.From, e.To, attrs)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// isDispatcher tells whether the type looks like a generated dispatcher
+// pointer: it has Sub and Count methods and an Emit method or field.
+func isDispatcher(typ types.Type) bool {
+	if _, ok := typ.(*types.Pointer); !ok {
+		return false
+	}
+	for _, name := range []string{"Sub", "Count"} {
+		obj, _, _ := types.LookupFieldOrMethod(typ, false, nil, name)
+		if _, ok := obj.(*types.Func); !ok {
+			return false
+		}
+	}
+	obj, _, _ := types.LookupFieldOrMethod(typ, false, nil, "Emit")
+	return obj != nil
+}
+
+func derefType(typ types.Type) types.Type {
+	if ptr, ok := typ.(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return typ
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
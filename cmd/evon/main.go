@@ -29,55 +29,131 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/types"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/lych77/evon"
 	"golang.org/x/text/width"
 	"golang.org/x/tools/go/packages"
 )
 
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// loadMode leaves out packages.NeedDeps: dependencies are type checked from
+// their export data instead of being parsed from source.
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo
+
 var (
 	flagHandlerSuffix = flag.String("handler_suffix", "Handler", "Required suffix of the event handler type names")
 	flagEventSuffix   = flag.String("event_suffix", "Event", "Suffix of the generated event type names")
 	flagOut           = flag.String("out", "evon_gen.go", `Output source file name`)
 	flagTags          = flag.String("tags", "", `Comma-separated Go build tags`)
+	flagRecordOut     = flag.String("record_out", "", `Output source file name for recorders, e.g. "evon_gen_test.go" (default the same as -out)`)
 	flagShow          = flag.Bool("show", false, "Show event handler types without generation")
+	flagFormat        = flag.String("format", formatText, `Output format of -show, "text" or "json"`)
+	flagTemplate      = flag.String("template", "", "User template file rendered after every generated dispatcher")
+	flagGroup         = flag.String("group", "Group", `Name of the package-level type generated for the "owner" flag`)
+	flagCheck         = flag.Bool("check", false, "Check that generated files are up to date without writing them, printing the differences")
 )
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [dir]\nFlags:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [packages]\n       %s [flags] graph [graph flags] [packages]\nFlags:\n", os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	dir, err := filepath.Abs(flag.Arg(0))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+	if flag.Arg(0) == "graph" {
+		if !runGraph(flag.Args()[1:]) {
+			os.Exit(1)
+		}
 		return
 	}
 
+	if *flagFormat != formatText && *flagFormat != formatJSON {
+		fmt.Fprintf(os.Stderr, "Fatal: Invalid format \"%s\"\n", *flagFormat)
+		os.Exit(2)
+	}
+
+	if *flagTemplate != "" {
+		src, err := os.ReadFile(*flagTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
+			os.Exit(1)
+		}
+		userTemplate = string(src)
+	}
+
+	patterns := flag.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
 	cfg := &packages.Config{
-		Mode:       packages.NeedName | packages.NeedImports | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo | packages.NeedDeps,
-		Dir:        dir,
+		Mode:       loadMode,
 		BuildFlags: []string{"-tags=" + *flagTags},
 	}
 
-	pkgs, err := packages.Load(cfg)
+	pkgs, err := packages.Load(cfg, localPatterns(patterns)...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Fatal: %s\n", err)
-		return
+		os.Exit(1)
 	}
 
-	if !process(pkgs[0], filepath.Join(dir, *flagOut)) {
+	ok := true
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) == 0 {
+			// Nothing to generate in directories with test files only, unless they fail to load
+			for _, e := range pkg.Errors {
+				fmt.Fprintf(os.Stderr, "[go] %s\n", e)
+				ok = false
+			}
+			continue
+		}
+
+		if !process(pkg, filepath.Join(filepath.Dir(pkg.GoFiles[0]), *flagOut)) {
+			ok = false
+		}
+	}
+
+	if *flagShow && *flagFormat == formatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		enc.Encode(shownDecls)
+	}
+
+	if !ok {
 		os.Exit(1)
 	}
 }
 
+// localPatterns turns bare relative directories like "foo" or "foo/..." into
+// "./foo" and "./foo/...", which would otherwise be taken as import paths.
+func localPatterns(patterns []string) []string {
+	res := make([]string, len(patterns))
+	for i, p := range patterns {
+		res[i] = p
+		if filepath.IsAbs(p) || strings.HasPrefix(p, ".") {
+			continue
+		}
+		if fi, err := os.Stat(strings.TrimSuffix(p, "/...")); err == nil && fi.IsDir() {
+			res[i] = "./" + p
+		}
+	}
+	return res
+}
+
 func process(pkg *packages.Package, path string) bool {
 	for _, e := range pkg.Errors {
 		if !strings.HasPrefix(e.Pos, path) {
@@ -85,33 +161,58 @@ func process(pkg *packages.Package, path string) bool {
 		}
 	}
 
-	par := newParser(pkg)
-	par.ParsePkg()
-
-	if len(par.Errors) > 0 {
-		for _, e := range par.Errors {
+	model, err := evon.Parse(pkg, options())
+	if errs, ok := err.(evon.ErrorList); ok {
+		for _, e := range errs {
 			fmt.Fprintf(os.Stderr, "[evon] %s\n", e)
 		}
 		return false
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "[evon] %s\n", err)
+		return false
 	}
 
-	if len(par.Decls) == 0 {
-		fmt.Println("(No handler types detected)")
-		if !*flagShow {
-			os.Remove(path)
+	if len(model.Decls) == 0 {
+		if !*flagShow || *flagFormat != formatJSON {
+			fmt.Printf("(No handler types detected in %s)\n", pkg.PkgPath)
 		}
-		return true
+		if *flagShow {
+			return true
+		}
+		ok := removeFile(path)
+		if *flagRecordOut != "" {
+			ok = removeFile(filepath.Join(filepath.Dir(path), *flagRecordOut)) && ok
+		}
+		return ok
 	}
 
 	if *flagShow {
-		showSummary(par)
+		showSummary(model)
 		return true
 	}
 
-	return generate(par, path)
+	return generate(model, path)
 }
 
-func showSummary(par *parser) {
+// userTemplate is the content of the -template file.
+var userTemplate string
+
+func options() *evon.Options {
+	return &evon.Options{
+		HandlerSuffix:     *flagHandlerSuffix,
+		EventSuffix:       *flagEventSuffix,
+		SeparateRecorders: *flagRecordOut != "",
+		Template:          userTemplate,
+		GroupName:         *flagGroup,
+	}
+}
+
+func showSummary(model *evon.Model) {
+	if *flagFormat == formatJSON {
+		shownDecls = append(shownDecls, showDecls(model)...)
+		return
+	}
+
 	type showRow struct {
 		Kind      string
 		Name      *ast.Ident
@@ -123,7 +224,7 @@ func showSummary(par *parser) {
 	maxNameWidth := 0
 	maxFlagsWidth := 0
 
-	for _, decl := range par.Decls {
+	for _, decl := range model.Decls {
 		flags := decl.Ann.FormatFlags()
 		if len(flags) > maxFlagsWidth {
 			maxFlagsWidth = len(flags)
@@ -151,8 +252,108 @@ func showSummary(par *parser) {
 		fmt.Printf("%s %s %s %s\n", r.Kind,
 			r.Name.Name+strings.Repeat(" ", maxNameWidth-r.NameWidth),
 			r.Flags+strings.Repeat(" ", maxFlagsWidth-len(r.Flags)),
-			par.Pkg.Fset.Position(r.Name.NamePos))
+			model.Pkg.Fset.Position(r.Name.NamePos))
+	}
+}
+
+type showDecl struct {
+	Kind     string        `json:"kind"`
+	Handler  string        `json:"handler"`
+	Event    string        `json:"event"`
+	Package  string        `json:"package"`
+	Flags    []string      `json:"flags"`
+	Template string        `json:"template,omitempty"`
+	Pos      string        `json:"pos"`
+	Methods  []*showMethod `json:"methods"`
+}
+
+type showMethod struct {
+	Name      string       `json:"name"`
+	Signature string       `json:"signature"`
+	Params    []*showParam `json:"params"`
+	Results   []string     `json:"results"`
+	Variadic  bool         `json:"variadic"`
+}
+
+type showParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// shownDecls collects declarations from all packages for -show -format=json.
+var shownDecls = []*showDecl{}
+
+func showDecls(model *evon.Model) []*showDecl {
+	qual := func(p *types.Package) string {
+		if p == model.Pkg.Types {
+			return ""
+		}
+		return p.Name()
+	}
+
+	res := []*showDecl{}
+	for _, decl := range model.Decls {
+		name := decl.Event.Name.Name
+		sd := &showDecl{
+			Kind:     "interface",
+			Handler:  name,
+			Event:    name[:len(name)-len(*flagHandlerSuffix)] + *flagEventSuffix,
+			Package:  model.Pkg.PkgPath,
+			Flags:    []string{},
+			Template: decl.Ann.Template,
+			Pos:      model.Pkg.Fset.Position(decl.Event.Name.NamePos).String(),
+			Methods:  []*showMethod{},
+		}
+		if decl.Event.Funcs[0].Name == "" {
+			sd.Kind = "func"
+		}
+		for f := range decl.Ann.Flags {
+			sd.Flags = append(sd.Flags, f)
+		}
+		sort.Strings(sd.Flags)
+
+		for _, f := range decl.Event.Funcs {
+			sm := &showMethod{Name: f.Name, Params: []*showParam{}, Results: []string{}}
+
+			params := []string{}
+			i := 0
+			for _, g := range f.Type.Params.List {
+				_, sm.Variadic = g.Type.(*ast.Ellipsis)
+				names := []string{""}
+				if len(g.Names) > 0 {
+					names = names[:0]
+					for _, n := range g.Names {
+						names = append(names, n.Name)
+					}
+				}
+				for _, n := range names {
+					typ := types.TypeString(f.Params[i], qual)
+					if slice, ok := f.Params[i].(*types.Slice); ok && sm.Variadic {
+						typ = "..." + types.TypeString(slice.Elem(), qual)
+					}
+					sm.Params = append(sm.Params, &showParam{Name: n, Type: typ})
+					params = append(params, strings.TrimSpace(n+" "+typ))
+					i++
+				}
+			}
+
+			for _, r := range f.Results {
+				sm.Results = append(sm.Results, types.TypeString(r, qual))
+			}
+
+			sm.Signature = "func(" + strings.Join(params, ", ") + ")"
+			if len(sm.Results) == 1 {
+				sm.Signature += " " + sm.Results[0]
+			} else if len(sm.Results) > 1 {
+				sm.Signature += " (" + strings.Join(sm.Results, ", ") + ")"
+			}
+
+			sd.Methods = append(sd.Methods, sm)
+		}
+
+		res = append(res, sd)
 	}
+	return res
 }
 
 func monospaceLen(s string) int {
@@ -0,0 +1,369 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"go/printer"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lych77/evon"
+	"golang.org/x/tools/go/packages"
+)
+
+// testdata/imports imports a sibling package and net/http, and its generated
+// file imports sync and context, all of which are loaded from export data.
+const importsDir = "testdata/imports"
+
+func TestGenerateTwice(t *testing.T) {
+	dir := t.TempDir()
+	copyDir(t, importsDir, dir)
+	path := filepath.Join(dir, *flagOut)
+
+	var first []byte
+	for i := 1; i <= 2; i++ {
+		if !process(loadPackage(t, dir, loadMode), path) {
+			t.Fatalf("Run %d failed", i)
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = src
+		} else if !bytes.Equal(first, src) {
+			t.Errorf("Run %d changed %s", i, path)
+		}
+	}
+
+	*flagCheck = true
+	defer func() { *flagCheck = false }()
+	if !process(loadPackage(t, dir, loadMode), path) {
+		t.Errorf("-check failed on freshly generated %s", path)
+	}
+}
+
+// TestGenerateConcurrently generates from one model in parallel, as analyzers
+// sharing a package may do, which must neither race nor touch the syntax.
+func TestGenerateConcurrently(t *testing.T) {
+	pkg := loadPackage(t, importsDir, loadMode)
+	model, err := evon.Parse(pkg, options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := printSyntax(t, pkg)
+
+	srcs := make([][]byte, 4)
+	var wg sync.WaitGroup
+	for i := range srcs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			src, err := evon.Generate(model, options())
+			if err != nil {
+				t.Error(err)
+			}
+			srcs[i] = src
+		}(i)
+	}
+	wg.Wait()
+
+	for i, src := range srcs[1:] {
+		if !bytes.Equal(srcs[0], src) {
+			t.Errorf("Generation %d differs from the first one", i+1)
+		}
+	}
+	if !bytes.Equal(before, printSyntax(t, pkg)) {
+		t.Error("Generate changed the syntax of the package")
+	}
+}
+
+// Handler types the flag combinations below are applied to.
+const (
+	funcShape        = "func(k string, n int)"
+	resultsShape     = "func(k string, n int) (int, error)"
+	intfShape        = "interface {\n\tAdded(k string, n int)\n\tRemoved(k string)\n}"
+	intfResultsShape = "interface {\n\tScore(k string, n int) (int, error)\n\tNote(k string)\n}"
+)
+
+var flagCombos = []struct {
+	flags, shape string
+}{
+	{"", funcShape},
+	{"lock", funcShape},
+	{"unsub", funcShape},
+	{"unsub, lock, context", funcShape},
+	{"unsub, handle, lock, context", funcShape},
+	{"owner, unsub, lock, context", funcShape},
+	{"spawn", funcShape},
+	{"spawn, wait, lock", funcShape},
+	{"queue", funcShape},
+	{"queue, wait, unsub, handle", funcShape},
+	{"catch", funcShape},
+	{"guard, catch", funcShape},
+	{"guard, catch, queue, unsub, lock", funcShape},
+	{"guard, catch, reduce", resultsShape},
+	{"guard, catch, spawn, wait, reduce", resultsShape},
+	{"guard, catch, lock", intfShape},
+	{"route, unsub, lock", funcShape},
+	{"route, context, unsub, lock", funcShape},
+	{"route, call", resultsShape},
+	{"route, dedup, unsub, owner", intfShape},
+	{"route, dedup, unsub, handle, lock", intfShape},
+	{"balance", funcShape},
+	{"balance, hash, lock", funcShape},
+	{"balance, handle, unsub", funcShape},
+	{"balance, handle, unsub, queue, lock", funcShape},
+	{"pause, lock", funcShape},
+	{"pause, handle, unsub, spawn, lock", funcShape},
+	{"lazy, unsub", funcShape},
+	{"lazy, unsub, lock, owner", funcShape},
+	{"lazy, unsub, lock, route, handle", funcShape},
+	{"limit", funcShape},
+	{"limit, unsub, lock", funcShape},
+	{"limit, handle, unsub", funcShape},
+	{"reduce", resultsShape},
+	{"reduce, spawn, wait", resultsShape},
+	{"reduce, queue, wait", resultsShape},
+	{"reduce, lock", intfResultsShape},
+	{"reduce, topics", resultsShape},
+	{"reduce, topics", intfResultsShape},
+	{"topics", funcShape},
+	{"topics, unsub, lock", funcShape},
+	{"topics, unsub, handle", funcShape},
+	{"topics, lock", intfShape},
+	{"call", resultsShape},
+	{"call, catch", intfResultsShape},
+	{"base", intfShape},
+	{"funcs, unsub", intfShape},
+	{"msg", intfShape},
+	{"chan, unsub", intfShape},
+	{"dedup, unsub, lock", intfShape},
+	{"record", intfShape},
+	{"funcs, chan, unsub, base, msg, record, call", intfResultsShape},
+	{"lock, unsub, handle, queue, wait, catch, guard, lazy, limit, pause, route, owner, context, reduce, call, dedup, funcs, chan, msg, base, record, topics", intfResultsShape},
+}
+
+// TestFlagCombos generates a package for every combination in flagCombos
+// and checks that the generated code passes go vet.
+func TestFlagCombos(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go vet")
+	}
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "go.mod"), "module example.com/combos\n\ngo 1.25\n")
+	shapeNames := map[string]string{
+		funcShape:        "func",
+		resultsShape:     "results",
+		intfShape:        "intf",
+		intfResultsShape: "intfresults",
+	}
+	for _, c := range flagCombos {
+		name := "none"
+		if c.flags != "" {
+			name = strings.ReplaceAll(c.flags, ", ", "_")
+		}
+		pkgDir := filepath.Join(dir, shapeNames[c.shape], name)
+		writeTestFile(t, filepath.Join(pkgDir, "combo.go"), "package combo\n\n// @evon("+c.flags+")\ntype ComboHandler "+c.shape+"\n")
+		if !process(loadPackage(t, pkgDir, loadMode), filepath.Join(pkgDir, *flagOut)) {
+			t.Fatalf("Generation failed for %s with @evon(%s)", shapeNames[c.shape], c.flags)
+		}
+	}
+
+	runGo(t, dir, "vet", "./...")
+}
+
+// TestBehaviour runs the tests of testdata/behaviour against its generated
+// dispatchers.
+func TestBehaviour(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+
+	dir := t.TempDir()
+	copyDir(t, "testdata/behaviour", dir)
+	if !process(loadPackage(t, dir, loadMode), filepath.Join(dir, *flagOut)) {
+		t.Fatal("Generation failed")
+	}
+	runGo(t, dir, "test", "-count=1", ".")
+}
+
+// TestGraphNodes checks that the graph only has dispatchers taking part in
+// chaining, not the receivers of Sub calls inside the generated code.
+func TestGraphNodes(t *testing.T) {
+	g := chainGraph(t, "var login, audit = NewLoginEvent(), NewLoginEvent()\n\nfunc init() { login.Sub(audit.Emit) }\n")
+	ids := []string{}
+	for _, n := range g.Nodes {
+		ids = append(ids, n.ID)
+	}
+	if want := "example.com/imports.login example.com/imports.audit"; strings.Join(ids, " ") != want {
+		t.Errorf("Nodes are %q, want %q", ids, want)
+	}
+	if len(g.Edges) != 1 || len(g.Cycles) != 0 {
+		t.Errorf("Got %d edges and %d cycles, want 1 and 0", len(g.Edges), len(g.Cycles))
+	}
+}
+
+// TestGraphFields checks that subscribing a field to the same field of
+// another instance is only a possible cycle, while loops between variables
+// and between fields of the same variable are certain ones.
+func TestGraphFields(t *testing.T) {
+	g := chainGraph(t, `
+type node struct {
+	changed, moved *LoginEvent
+}
+
+func (n *node) attach(p *node) { n.changed.Sub(p.changed.Emit) }
+
+func (n *node) loop() {
+	n.changed.Sub(n.moved.Emit)
+	n.moved.Sub(n.changed.Emit)
+}
+
+var login, audit = NewLoginEvent(), NewLoginEvent()
+
+func init() {
+	login.Sub(audit.Emit)
+	audit.Sub(login.Emit)
+}
+`)
+	if len(g.Cycles) != 2 || len(g.PossibleCycles) != 1 {
+		t.Fatalf("Got %d cycles and %d possible ones, want 2 and 1", len(g.Cycles), len(g.PossibleCycles))
+	}
+	if e := g.PossibleCycles[0]; len(e) != 1 || e[0].From != "example.com/imports.node.changed" {
+		t.Errorf("Possible cycle is %v", e)
+	}
+}
+
+// chainGraph builds the graph of testdata/imports with src added to it.
+func chainGraph(t *testing.T, src string) *graph {
+	dir := t.TempDir()
+	copyDir(t, importsDir, dir)
+	if !process(loadPackage(t, dir, loadMode), filepath.Join(dir, *flagOut)) {
+		t.Fatal("Generation failed")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "chain.go"), []byte("package imports\n"+src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return buildGraph([]*packages.Package{loadPackage(t, dir, loadMode)})
+}
+
+func BenchmarkLoad(b *testing.B) {
+	benchmarkLoad(b, loadMode)
+}
+
+// BenchmarkLoadDeps loads the same package the former way, parsing and type
+// checking all dependencies from source.
+func BenchmarkLoadDeps(b *testing.B) {
+	benchmarkLoad(b, loadMode|packages.NeedDeps)
+}
+
+func benchmarkLoad(b *testing.B, mode packages.LoadMode) {
+	for i := 0; i < b.N; i++ {
+		model, err := evon.Parse(loadPackage(b, importsDir, mode), options())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := evon.Generate(model, options()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func loadPackage(tb testing.TB, dir string, mode packages.LoadMode) *packages.Package {
+	pkgs, err := packages.Load(&packages.Config{Mode: mode, Dir: dir}, ".")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(pkgs) != 1 {
+		tb.Fatalf("Loaded %d packages from %s", len(pkgs), dir)
+	}
+	for _, e := range pkgs[0].Errors {
+		tb.Error(e)
+	}
+	if tb.Failed() {
+		tb.FailNow()
+	}
+	return pkgs[0]
+}
+
+func printSyntax(t *testing.T, pkg *packages.Package) []byte {
+	var buf bytes.Buffer
+	for _, f := range pkg.Syntax {
+		if err := printer.Fprint(&buf, pkg.Fset, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func copyDir(tb testing.TB, src, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, path[len(src):])
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func writeTestFile(tb testing.TB, path, src string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// runGo runs the go command in dir, outside of any workspace.
+func runGo(tb testing.TB, dir string, args ...string) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		tb.Fatalf("go %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+}
@@ -0,0 +1,221 @@
+package behaviour
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type keyCounter struct {
+	lock sync.Mutex
+	keys []string
+}
+
+func (c *keyCounter) On(k string, n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.keys = append(c.keys, k)
+}
+
+func TestRouteDedupUnsub(t *testing.T) {
+	ev := NewKeyEvent()
+	h := &keyCounter{}
+	ev.SubKey("a", h)
+	ev.SubKey("b", h)
+	unsub := ev.SubKey("a", h)
+	if n := ev.Count(); n != 2 {
+		t.Fatalf("Count is %d after a duplicate subscription, want 2", n)
+	}
+
+	unsub()
+	if n, nb := ev.Count(), ev.CountKey("b"); n != 1 || nb != 1 {
+		t.Fatalf("Count is %d and CountKey(b) is %d after unsubscribing a, want 1 and 1", n, nb)
+	}
+	ev.Emit.On("a", 1)
+	ev.Emit.On("b", 1)
+	if !reflect.DeepEqual(h.keys, []string{"b"}) {
+		t.Errorf("Handled keys %v, want [b]", h.keys)
+	}
+}
+
+func TestLazyTransitions(t *testing.T) {
+	var ev *LazyEvent
+	hooks := []string{}
+	ev = NewLazyEvent(func() {
+		hooks = append(hooks, "active")
+		ev.Count() // Hooks run without the lock held
+	}, func() {
+		hooks = append(hooks, "idle")
+	})
+
+	unsub1 := ev.Sub(func(int) {})
+	unsub2 := ev.Sub(func(int) {})
+	unsub1()
+	unsub2()
+	ev.SubOwned(t, func(int) {})
+	ev.UnsubOwner(t)
+	ev.Sub(func(int) {})
+	ev.Clear()
+
+	want := []string{"active", "idle", "active", "idle", "active", "idle"}
+	if !reflect.DeepEqual(hooks, want) {
+		t.Errorf("Hooks called %v, want %v", hooks, want)
+	}
+}
+
+func TestLimitCallSite(t *testing.T) {
+	type report struct {
+		count int
+		file  string
+		line  int
+	}
+	reports := []report{}
+	ev := NewLimitEvent(1, func(count int, file string, line int) bool {
+		reports = append(reports, report{count, file, line})
+		return false
+	})
+
+	ev.Sub(func(int) {})
+	_, file, line, _ := runtime.Caller(0)
+	ev.Sub(func(int) {})
+	ev.Sub(func(int) {})
+
+	want := []report{{2, file, line + 1}}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("Limit reported %v, want %v", reports, want)
+	}
+}
+
+// catcher counts the cycle errors passed to catch, failing on other panics.
+type catcher struct {
+	t      *testing.T
+	lock   sync.Mutex
+	cycles int
+}
+
+func (c *catcher) catch(e interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch e {
+	case ErrSyncCycle, ErrSpawnCycle, ErrQueueCycle:
+		c.cycles++
+	default:
+		c.t.Errorf("Unexpected panic: %v", e)
+	}
+}
+
+func (c *catcher) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cycles
+}
+
+func TestGuardSync(t *testing.T) {
+	c := &catcher{t: t}
+	a, b, d := NewSyncEvent(c.catch), NewSyncEvent(c.catch), NewSyncEvent(c.catch)
+	// A diamond is no cycle
+	n := 0
+	a.Sub(b.Emit)
+	a.Sub(d.Emit)
+	b.Sub(d.Emit)
+	d.Sub(func(int) { n++ })
+	a.Emit(1)
+	if n != 2 || c.count() != 0 {
+		t.Fatalf("Diamond handled %d times with %d cycles, want 2 and 0", n, c.count())
+	}
+
+	d.Sub(a.Emit)
+	a.Emit(1)
+	if c.count() != 2 {
+		t.Errorf("Got %d cycles, want 2", c.count())
+	}
+}
+
+func TestGuardSpawn(t *testing.T) {
+	c := &catcher{t: t}
+	a, b := NewSpawnEvent(c.catch), NewSpawnEvent(c.catch)
+	a.Sub(b.Emit)
+	b.Sub(a.Emit)
+	a.Emit(1)
+	if c.count() != 1 {
+		t.Errorf("Got %d cycles, want 1", c.count())
+	}
+}
+
+func TestGuardQueue(t *testing.T) {
+	c := &catcher{t: t}
+	a, b := NewQueueEvent(4, c.catch), NewQueueEvent(4, c.catch)
+	a.Sub(b.Emit)
+	b.Sub(a.Emit)
+	a.Emit(1)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if c.count() != 1 {
+		t.Errorf("Got %d cycles, want 1", c.count())
+	}
+}
+
+func TestTopicMatching(t *testing.T) {
+	tp := NewOrderTopics(".")
+	got := []string{}
+	sub := func(pattern string) func() {
+		return tp.Sub(pattern, func(id int) { got = append(got, pattern) })
+	}
+	unsubs := []func(){
+		sub("orders.*.created"),
+		sub("orders.#"),
+		sub("orders.eu.*"),
+		sub("#"),
+		sub("orders.eu.created.#"),
+	}
+
+	cases := []struct {
+		topic string
+		want  []string
+	}{
+		{"orders.eu.created", []string{"#", "orders.#", "orders.*.created", "orders.eu.*", "orders.eu.created.#"}},
+		{"orders.us.created", []string{"#", "orders.#", "orders.*.created"}},
+		{"orders", []string{"#", "orders.#"}},
+		{"users.eu.created", []string{"#"}},
+		{"orders.eu", []string{"#", "orders.#"}},
+	}
+	for _, c := range cases {
+		got = got[:0]
+		tp.Emit(c.topic, 1)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Topic %q matched %v, want %v", c.topic, got, c.want)
+		}
+	}
+
+	for _, unsub := range unsubs {
+		unsub()
+	}
+	if tp.root.rest != nil || len(tp.root.children) != 0 {
+		t.Errorf("Topic trie not emptied after unsubscribing all patterns")
+	}
+}
+
+func TestRoundRobinPaused(t *testing.T) {
+	ev := NewWorkEvent()
+	counts := make([]int, 3)
+	subs := []*WorkSubscription{}
+	for i := range counts {
+		i := i
+		subs = append(subs, ev.Sub(func(int) { counts[i]++ }))
+	}
+	subs[1].Pause()
+	for i := 0; i < 30; i++ {
+		ev.Emit(i)
+	}
+	if want := []int{15, 0, 15}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Handled %v times, want %v", counts, want)
+	}
+}
@@ -0,0 +1,27 @@
+package behaviour
+
+// @evon(route, dedup, unsub, lock)
+type KeyHandler interface {
+	On(k string, n int)
+}
+
+// @evon(lazy, unsub, lock, owner)
+type LazyHandler func(n int)
+
+// @evon(limit, unsub, lock)
+type LimitHandler func(n int)
+
+// @evon(guard, catch, lock)
+type SyncHandler func(n int)
+
+// @evon(guard, catch, spawn, wait, lock)
+type SpawnHandler func(n int)
+
+// @evon(guard, catch, queue, unsub, lock)
+type QueueHandler func(n int)
+
+// @evon(topics, unsub, lock)
+type OrderHandler func(id int)
+
+// @evon(balance, handle, unsub, lock)
+type WorkHandler func(n int)
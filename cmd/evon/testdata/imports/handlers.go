@@ -0,0 +1,21 @@
+package imports
+
+import (
+	"net/http"
+
+	"example.com/imports/session"
+)
+
+// @evon(lock, unsub, context)
+type LoginHandler func(s *session.Info, req *http.Request)
+
+// @evon(lock, funcs, record)
+type ServerHandler interface {
+	Started(srv *http.Server)
+	Failed(err error)
+}
+
+// @evon(lock)
+type TrackHandler interface {
+	session.Tracker
+}
@@ -0,0 +1,26 @@
+package session
+
+import "time"
+
+type Info struct {
+	ID      string
+	Expires time.Time
+}
+
+type ID = string
+
+type seconds = int64
+
+type List[T any] []T
+
+type Pair[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+type Tracker interface {
+	Seen(id ID)
+	Idle(d seconds)
+	Batch(l List[int])
+	Pairs(ps []Pair[ID, *Info])
+}
@@ -26,35 +26,16 @@
 // OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
 // OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 
+// Command evonvet runs the evon analyzer standalone, or as a vet tool:
+//
+//	go vet -vettool=$(which evonvet) ./...
 package main
 
 import (
-	"strconv"
+	"github.com/lych77/evon/analyzer"
+	"golang.org/x/tools/go/analysis/singlechecker"
 )
 
-type void = struct{}
-
-type dedupSet map[string]bool
-
-func newDedupSet(preset ...string) dedupSet {
-	set := make(dedupSet)
-	for _, p := range preset {
-		set[p] = true
-	}
-	return set
-}
-
-func (set dedupSet) Resolve(name string) string {
-	alias := name
-	for i := 1; set[alias]; i++ {
-		alias = name + strconv.Itoa(i)
-	}
-	set[alias] = true
-	return alias
-}
-
-func (set dedupSet) Merge(other dedupSet) {
-	for n := range other {
-		set[n] = true
-	}
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
 }
@@ -0,0 +1,144 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+// Package evon is the library behind the evon command, for other code generators
+// to parse annotated handler types of a package and generate event dispatchers
+// for them, or to post-process the parsed model, without shelling out.
+//
+// Packages are loaded by the caller with golang.org/x/tools/go/packages, in at
+// least the NeedName, NeedImports, NeedSyntax, NeedTypes and NeedTypesInfo
+// modes. Dependencies are not needed.
+package evon
+
+import (
+	"fmt"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Options controls parsing and generation, with empty values standing for the
+// defaults of the evon command.
+type Options struct {
+	// HandlerSuffix is the required suffix of the handler type names, "Handler" by default
+	HandlerSuffix string
+	// EventSuffix is the suffix of the generated event type names, "Event" by default
+	EventSuffix string
+	// SeparateRecorders leaves recorders out of Generate, for GenerateRecorders to
+	// render them into another file
+	SeparateRecorders bool
+	// Template is the source of a user text/template, see the README for details
+	Template string
+	// GroupName is the name of the package-level type generated for the owner flag,
+	// "Group" by default
+	GroupName string
+}
+
+func (opts *Options) normalize() *Options {
+	res := Options{}
+	if opts != nil {
+		res = *opts
+	}
+	if res.HandlerSuffix == "" {
+		res.HandlerSuffix = "Handler"
+	}
+	if res.EventSuffix == "" {
+		res.EventSuffix = "Event"
+	}
+	if res.GroupName == "" {
+		res.GroupName = "Group"
+	}
+	return &res
+}
+
+// Model is the result of parsing a package, holding all annotated handler type
+// declarations in it. Generating from a model leaves it and the syntax of the
+// package unchanged, and can be done concurrently.
+type Model struct {
+	Pkg   *packages.Package
+	Decls []*Decl
+
+	handlerSuffix string
+	imports       importMap
+}
+
+// ErrorList holds all errors found by Parse.
+type ErrorList []error
+
+func (errs ErrorList) Error() string {
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Error is a problem found by Parse at a source position, formatted with the
+// position in front.
+type Error struct {
+	Fset *token.FileSet
+	Pos  token.Pos
+	Msg  string
+	// Fix is a suggested source edit fixing the problem, if any
+	Fix *Fix
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("%s: %s", err.Fset.Position(err.Pos), err.Msg)
+}
+
+// Fix is a suggested edit replacing the source between Pos and End with NewText.
+type Fix struct {
+	Message string
+	Pos     token.Pos
+	End     token.Pos
+	NewText string
+}
+
+func errorf(fset *token.FileSet, pos token.Pos, format string, args ...interface{}) *Error {
+	return &Error{Fset: fset, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Parse finds the annotated handler types in the package. The returned error,
+// if any, is an ErrorList.
+func Parse(pkg *packages.Package, opts *Options) (*Model, error) {
+	par := newParser(pkg, opts.normalize())
+	par.ParsePkg()
+
+	if len(par.Errors) > 0 {
+		return nil, ErrorList(par.Errors)
+	}
+
+	return &Model{
+		Pkg:           pkg,
+		Decls:         par.Decls,
+		handlerSuffix: par.Opts.HandlerSuffix,
+		imports:       par.Imports,
+	}, nil
+}
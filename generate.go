@@ -0,0 +1,616 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type genFile struct {
+	Package string
+	Imports []*genImport
+	Events  []*genEvent
+
+	HandlerSuffix string
+	EventSuffix   string
+
+	SyncAlias      string
+	SyncAliasLocal string
+	TimeAlias      string
+	StringsAlias   string
+	AtomicAlias    string
+	ErrorsAlias    string
+	ContextAlias   string
+	RuntimeAlias   string
+
+	Recorders     bool
+	RecordersOnly bool
+	Group         string
+	Guard         bool
+	UserImports   bool
+}
+
+type genImport struct {
+	Alias string
+	Path  string
+}
+
+type genEvent struct {
+	Name     string
+	Flags    map[string]bool
+	FlagsLit string
+	Funcs    []*genFunc
+	KeyType  string
+	Dedups   map[string]string
+	Template string
+	File     *genFile
+}
+
+type genFunc struct {
+	Name       string
+	FuncField  string
+	Sig        string
+	Args       string
+	Params     []*genParam
+	ParamsSig  string
+	Variadic   bool
+	HasResults bool
+	Results    []*genParam
+	Handled    string
+	ReduceType string
+}
+
+type genParam struct {
+	Name  string
+	Field string
+	Type  string
+	Zero  string
+	Local string
+}
+
+// Generate renders the dispatchers of the model into a formatted Go source file.
+// Recorders are included unless opts.SeparateRecorders is set.
+func Generate(model *Model, opts *Options) ([]byte, error) {
+	g := newGenerator(model, opts)
+
+	tpl, err := g.parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+	return renderFile(tpl, g.buildFile())
+}
+
+// GenerateRecorders renders the recorders of the model into a separate formatted
+// Go source file when opts.SeparateRecorders is set. It returns nil if there is
+// nothing to render.
+func GenerateRecorders(model *Model, opts *Options) ([]byte, error) {
+	g := newGenerator(model, opts)
+
+	if !g.opts.SeparateRecorders {
+		return nil, nil
+	}
+
+	tpl, err := g.parseTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	recFile := g.recorderFile(g.buildFile())
+	if recFile == nil {
+		return nil, nil
+	}
+	return renderFile(tpl, recFile)
+}
+
+type generator struct {
+	model      *Model
+	opts       *Options
+	imports    importMap
+	pkgNameSet dedupSet
+	// Names of the type idents qualified by import aliases, applied to the copies
+	// of type expressions being rendered, leaving the syntax of the package intact.
+	aliases map[*ast.Ident]string
+}
+
+func newGenerator(model *Model, opts *Options) *generator {
+	g := &generator{
+		model:   model,
+		opts:    opts.normalize(),
+		imports: model.imports.clone(),
+		aliases: make(map[*ast.Ident]string),
+	}
+
+	if !g.opts.SeparateRecorders {
+		for _, decl := range model.Decls {
+			if decl.Ann.Flags[annRecord] {
+				g.imports.record("sync", "sync", prioInternal)
+				g.imports.record("time", "time", prioInternal)
+			}
+		}
+	}
+
+	return g
+}
+
+func (g *generator) buildFile() *genFile {
+	file := &genFile{
+		Package:       g.model.Pkg.Name,
+		HandlerSuffix: g.model.handlerSuffix,
+		EventSuffix:   g.opts.EventSuffix,
+		Recorders:     !g.opts.SeparateRecorders,
+	}
+
+	importList := g.dedupImports()
+	paramNames := newDedupSet()
+
+	for _, decl := range g.model.Decls {
+		paramSet := newDedupSet()
+
+		fieldSet := newDedupSet()
+		for _, f := range decl.Event.Funcs {
+			fieldSet[f.Name] = true
+		}
+
+		gfs := []*genFunc{}
+		for _, f := range decl.Event.Funcs {
+			gf := &genFunc{Name: f.Name}
+			if f.Name != "" {
+				gf.FuncField = fieldSet.Resolve("On" + strings.Title(f.Name))
+			}
+			typ := &ast.FuncType{Params: copyFields(f.Type.Params, g.aliases), Results: copyFields(f.Type.Results, g.aliases)}
+			renderSignatureArgs(gf, typ, g.model.Pkg.Fset, paramSet)
+			renderResults(gf, f, typ, g.model.Pkg.Fset, paramSet)
+			gfs = append(gfs, gf)
+		}
+
+		ge := &genEvent{
+			Name:     decl.Event.Name.Name[:len(decl.Event.Name.Name)-len(g.model.handlerSuffix)],
+			Flags:    decl.Ann.Flags,
+			FlagsLit: decl.Ann.FormatFlags(),
+			Funcs:    gfs,
+			Dedups:   make(map[string]string),
+			Template: decl.Ann.Template,
+			File:     file,
+		}
+		if ge.Template == "" && g.opts.Template != "" {
+			ge.Template = userTemplateName
+		}
+
+		if ge.Flags[annOwner] {
+			file.Group = g.opts.GroupName
+		}
+		if ge.Flags[annGuard] {
+			file.Guard = true
+		}
+
+		if ge.Flags[annRoute] || ge.Flags[annHash] {
+			ge.KeyType = gfs[0].Params[0].Type
+		}
+
+		g.pkgNameSet.Merge(paramSet)
+		paramNames.Merge(paramSet)
+
+		for _, n := range localIdents {
+			ge.Dedups[n] = paramSet.Resolve(n)
+		}
+		file.Events = append(file.Events, ge)
+	}
+
+	for _, r := range importList {
+		gi := &genImport{Path: r.Path}
+		if r.Alias != r.Name {
+			gi.Alias = r.Alias
+		}
+		file.Imports = append(file.Imports, gi)
+	}
+
+	if rec, ok := g.imports["sync"]; ok {
+		file.SyncAlias = rec.Alias
+		file.SyncAliasLocal = file.SyncAlias
+
+		// Inside emitters a parameter may shadow the import, then another name is needed, which
+		// replaces the import unless the handler types refer to it
+		if rec.Local && paramNames[file.SyncAlias] {
+			file.SyncAliasLocal = g.pkgNameSet.Resolve(file.SyncAlias)
+			if len(rec.TypeIdents) > 0 || len(rec.PkgIdents) > 0 {
+				file.Imports = append(file.Imports, &genImport{Alias: file.SyncAliasLocal, Path: "sync"})
+			} else {
+				for _, gi := range file.Imports {
+					if gi.Path == "sync" {
+						gi.Alias = file.SyncAliasLocal
+					}
+				}
+				file.SyncAlias = file.SyncAliasLocal
+			}
+		}
+	}
+
+	if rec, ok := g.imports["time"]; ok {
+		file.TimeAlias = rec.Alias
+	}
+	if rec, ok := g.imports["strings"]; ok {
+		file.StringsAlias = rec.Alias
+	}
+	if rec, ok := g.imports["sync/atomic"]; ok {
+		file.AtomicAlias = rec.Alias
+	}
+	if rec, ok := g.imports["errors"]; ok {
+		file.ErrorsAlias = rec.Alias
+	}
+	if rec, ok := g.imports["context"]; ok {
+		file.ContextAlias = rec.Alias
+	}
+	if rec, ok := g.imports["runtime"]; ok {
+		file.RuntimeAlias = rec.Alias
+	}
+
+	return file
+}
+
+func (g *generator) recorderFile(file *genFile) *genFile {
+	identRecs := make(map[*ast.Ident]*importRec)
+	for _, r := range g.imports {
+		for id := range r.TypeIdents {
+			identRecs[id] = r
+		}
+		for id := range r.PkgIdents {
+			identRecs[id] = r
+		}
+	}
+
+	found := false
+	used := make(map[*importRec]bool)
+	for _, decl := range g.model.Decls {
+		if !decl.Ann.Flags[annRecord] {
+			continue
+		}
+		found = true
+		for _, f := range decl.Event.Funcs {
+			ast.Inspect(f.Type, func(node ast.Node) bool {
+				if id, ok := node.(*ast.Ident); ok && identRecs[id] != nil {
+					used[identRecs[id]] = true
+				}
+				return true
+			})
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	res := *file
+	res.Imports = nil
+	res.Recorders = true
+	res.RecordersOnly = true
+	res.Group = ""
+	res.Guard = false
+
+	for _, gi := range file.Imports {
+		if rec, ok := g.imports[gi.Path]; ok && used[rec] && gi.Path != "sync" && gi.Path != "time" {
+			res.Imports = append(res.Imports, gi)
+		}
+	}
+
+	addImport := func(path string) string {
+		alias := ""
+		if rec, ok := g.imports[path]; ok {
+			alias = rec.Alias
+		} else {
+			alias = g.pkgNameSet.Resolve(path)
+		}
+		gi := &genImport{Path: path}
+		if alias != path {
+			gi.Alias = alias
+		}
+		res.Imports = append(res.Imports, gi)
+		return alias
+	}
+	res.SyncAlias = addImport("sync")
+	res.TimeAlias = addImport("time")
+
+	return &res
+}
+
+func (g *generator) dedupImports() []*importRec {
+	recs := []*importRec{}
+	for _, r := range g.imports {
+		recs = append(recs, r)
+	}
+	sort.Slice(recs, func(i, j int) bool {
+		iPrio := recs[i].Priority
+		jPrio := recs[j].Priority
+		return iPrio < jPrio || iPrio == jPrio && recs[i].Path < recs[j].Path
+	})
+
+	g.pkgNameSet = newDedupSet()
+	for _, r := range recs {
+		r.Alias = g.pkgNameSet.Resolve(r.Name)
+
+		for id := range r.TypeIdents {
+			g.aliases[id] = r.Alias + "." + id.Name
+		}
+		for id := range r.PkgIdents {
+			g.aliases[id] = r.Alias
+		}
+	}
+
+	return recs
+}
+
+// copyFields copies a field list for the parameter and result names to be
+// changed during rendering, and the types to be qualified by import aliases,
+// without touching the model.
+func copyFields(list *ast.FieldList, aliases map[*ast.Ident]string) *ast.FieldList {
+	if list == nil {
+		return nil
+	}
+
+	res := &ast.FieldList{}
+	for _, f := range list.List {
+		field := *f
+		field.Type = cloneExpr(f.Type, aliases)
+		field.Names = nil
+		for _, n := range f.Names {
+			field.Names = append(field.Names, ast.NewIdent(n.Name))
+		}
+		res.List = append(res.List, &field)
+	}
+	return res
+}
+
+func renderSignatureArgs(gf *genFunc, typ *ast.FuncType, fset *token.FileSet, allParamSet dedupSet) {
+	paramSet := newDedupSet("_")
+	for _, pg := range typ.Params.List {
+		for _, n := range pg.Names {
+			if n.Name != "_" {
+				paramSet[n.Name] = true
+			}
+		}
+	}
+
+	args := []string{}
+	fieldSet := newDedupSet()
+
+	for _, pg := range typ.Params.List {
+		if len(pg.Names) == 0 {
+			pg.Names = append(pg.Names, ast.NewIdent("_"))
+		}
+
+		typBuf := &bytes.Buffer{}
+		if ell, ok := pg.Type.(*ast.Ellipsis); ok {
+			typBuf.WriteString("[]")
+			printer.Fprint(typBuf, fset, ell.Elt)
+		} else {
+			printer.Fprint(typBuf, fset, pg.Type)
+		}
+
+		for _, n := range pg.Names {
+			if n.Name == "_" {
+				n.Name = paramSet.Resolve("_")
+			}
+
+			field := "Arg" + strconv.Itoa(len(args))
+			if !strings.HasPrefix(n.Name, "_") {
+				field = strings.Title(n.Name)
+			}
+
+			gf.Params = append(gf.Params, &genParam{
+				Name:  n.Name,
+				Field: fieldSet.Resolve(field),
+				Type:  typBuf.String(),
+			})
+			args = append(args, n.Name)
+			allParamSet[n.Name] = true
+		}
+	}
+
+	gf.Args = strings.Join(args, ", ")
+	if len(typ.Params.List) > 0 {
+		if _, ok := typ.Params.List[len(typ.Params.List)-1].Type.(*ast.Ellipsis); ok {
+			gf.Args += "..."
+			gf.Variadic = true
+		}
+	}
+
+	if typ.Results != nil {
+		for _, pg := range typ.Results.List {
+			if len(pg.Names) == 0 {
+				pg.Names = append(pg.Names, ast.NewIdent("_"))
+			} else {
+				for _, n := range pg.Names {
+					n.Name = "_"
+				}
+			}
+		}
+		gf.HasResults = true
+	}
+
+	sigBuf := &bytes.Buffer{}
+	printer.Fprint(sigBuf, fset, typ)
+	gf.Sig = sigBuf.String()[4:]
+
+	sigBuf.Reset()
+	printer.Fprint(sigBuf, fset, &ast.FuncType{Params: typ.Params})
+	gf.ParamsSig = sigBuf.String()[4:]
+}
+
+func renderResults(gf *genFunc, f *Func, typ *ast.FuncType, fset *token.FileSet, allParamSet dedupSet) {
+	if typ.Results == nil {
+		return
+	}
+
+	nameSet := newDedupSet()
+	for _, p := range gf.Params {
+		nameSet[p.Name] = true
+	}
+
+	conds := []string{}
+	for _, pg := range typ.Results.List {
+		typBuf := &bytes.Buffer{}
+		printer.Fprint(typBuf, fset, pg.Type)
+
+		for range pg.Names {
+			res := &genParam{
+				Name: nameSet.Resolve("r" + strconv.Itoa(len(gf.Results))),
+				Type: typBuf.String(),
+			}
+			res.Zero = zeroValue(f.Results[len(gf.Results)], res.Type)
+			allParamSet[res.Name] = true
+
+			conds = append(conds, res.Name+" != "+res.Zero)
+			gf.Results = append(gf.Results, res)
+		}
+	}
+
+	typs := []string{}
+	for i, res := range gf.Results {
+		res.Local = nameSet.Resolve("v" + strconv.Itoa(i))
+		allParamSet[res.Local] = true
+		typs = append(typs, res.Type)
+	}
+
+	resTypes := strings.Join(typs, ", ")
+	if len(typs) > 1 {
+		resTypes = "(" + resTypes + ")"
+	}
+	gf.ReduceType = "func(" + strings.Join(append(typs, typs...), ", ") + ") " + resTypes
+
+	if last := gf.Results[len(gf.Results)-1]; isBool(f.Results[len(f.Results)-1]) {
+		gf.Handled = last.Name
+	} else {
+		gf.Handled = strings.Join(conds, " || ")
+	}
+}
+
+// userImportsName names the optional user template listing extra import specs.
+const userImportsName = "imports"
+
+// userTemplateName names the top level of the user template, rendered for every
+// dispatcher not choosing another one by the "template=name" option.
+const userTemplateName = "__evon_user__"
+
+// parseTemplates parses the built-in template together with the user one, so
+// that they share the helper funcs and the user one can reuse built-in parts.
+func (g *generator) parseTemplates() (*template.Template, error) {
+	tpl := template.New("")
+	funcs := template.FuncMap{
+		"prefix": prefixIdent,
+		"title":  strings.Title,
+		"list":   makeList,
+		"include": func(name string, data interface{}) (string, error) {
+			buf := &bytes.Buffer{}
+			err := tpl.ExecuteTemplate(buf, name, data)
+			return buf.String(), err
+		},
+	}
+	template.Must(tpl.Funcs(funcs).Parse(templateText))
+
+	// Only templates defined by the user can be picked by annotations, and they
+	// must not replace the internal ones
+	userNames := make(map[string]bool)
+	if g.opts.Template != "" {
+		user, err := template.New(userTemplateName).Funcs(funcs).Parse(g.opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range user.Templates() {
+			if tpl.Lookup(t.Name()) != nil {
+				return nil, fmt.Errorf(`Template "%s" is reserved by evon`, t.Name())
+			}
+			userNames[t.Name()] = true
+		}
+		for _, t := range user.Templates() {
+			if _, err := tpl.AddParseTree(t.Name(), t.Tree); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, decl := range g.model.Decls {
+		name := decl.Ann.Template
+		if name != "" && (!userNames[name] || name == userImportsName || name == userTemplateName) {
+			return nil, errorf(g.model.Pkg.Fset, decl.Ann.Pos, `Template "%s" is not defined by the user template`, name)
+		}
+	}
+
+	return tpl, nil
+}
+
+func renderFile(tpl *template.Template, file *genFile) ([]byte, error) {
+	file.UserImports = tpl.Lookup(userImportsName) != nil && !file.RecordersOnly
+
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, file); err != nil {
+		return nil, err
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+func prefixIdent(p, s string) string {
+	if token.IsExported(s) {
+		return strings.Title(p) + strings.Title(s)
+	}
+	return strings.ToLower(p) + strings.Title(s)
+}
+
+func makeList(items ...interface{}) []interface{} {
+	return items
+}
+
+func zeroValue(typ types.Type, typStr string) string {
+	if typ == nil {
+		return "*new(" + typStr + ")"
+	}
+	if isNilable(typ) {
+		return "nil"
+	}
+	if basic, ok := typ.Underlying().(*types.Basic); ok {
+		switch {
+		case basic.Info()&types.IsBoolean != 0:
+			return "false"
+		case basic.Info()&types.IsString != 0:
+			return `""`
+		case basic.Info()&types.IsNumeric != 0:
+			return "0"
+		}
+	}
+	return "*new(" + typStr + ")"
+}
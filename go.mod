@@ -1,8 +1,13 @@
 module github.com/lych77/evon
 
-go 1.15
+go 1.25.0
 
 require (
-	golang.org/x/text v0.3.6
-	golang.org/x/tools v0.1.3
+	golang.org/x/text v0.36.0
+	golang.org/x/tools v0.44.0
+)
+
+require (
+	golang.org/x/mod v0.35.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
 )
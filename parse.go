@@ -0,0 +1,586 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+type parser struct {
+	Pkg  *packages.Package
+	Opts *Options
+
+	Decls   []*Decl
+	Imports importMap
+	Errors  []error
+
+	resolver *typeResolver
+}
+
+// Decl is an annotated handler type declaration.
+type Decl struct {
+	Ann   *Annotation
+	Event *Event
+}
+
+// Event describes the handler type of a declaration.
+type Event struct {
+	// Name is the name of the declared handler type
+	Name *ast.Ident
+	// Funcs holds the only signature of a func handler type, or all usable methods
+	// of an interface one
+	Funcs []*Func
+	// Partial tells whether the interface has methods unusable outside its package
+	Partial bool
+}
+
+// Func is a handler signature, or method with its name.
+type Func struct {
+	// Name is the method name, or empty for func handler types
+	Name string
+	// Type is the signature syntax, synthesized from go/types for foreign types
+	Type    *ast.FuncType
+	Params  []types.Type
+	Results []types.Type
+}
+
+type importMap map[string]*importRec
+
+type importRec struct {
+	Path       string
+	Name       string
+	Alias      string
+	PkgIdents  map[*ast.Ident]void
+	TypeIdents map[*ast.Ident]void
+	Priority   int
+	Local      bool
+}
+
+const (
+	prioStd = iota
+	prioUser
+	prioInternal
+)
+
+func newParser(pkg *packages.Package, opts *Options) *parser {
+	return &parser{
+		Pkg:      pkg,
+		Opts:     opts,
+		Imports:  make(importMap),
+		resolver: newTypeResolver(pkg),
+	}
+}
+
+func (par *parser) ParsePkg() {
+	for _, f := range par.Pkg.Syntax {
+		par.ParseFile(f)
+	}
+	par.checkGroup()
+}
+
+// checkGroup reports a package-level declaration, outside files generated by
+// evon, conflicting with the group type generated for the owner flag.
+func (par *parser) checkGroup() {
+	for _, decl := range par.Decls {
+		if !decl.Ann.Flags[annOwner] {
+			continue
+		}
+		obj := par.Pkg.Types.Scope().Lookup(par.Opts.GroupName)
+		if obj != nil && !par.isGenerated(obj.Pos()) {
+			par.Errors = append(par.Errors, errorf(par.Pkg.Fset, decl.Ann.Pos,
+				`Type "%s" generated for flag "%s" is already declared at %s`,
+				par.Opts.GroupName, annOwner, par.Pkg.Fset.Position(obj.Pos())))
+		}
+		return
+	}
+}
+
+func (par *parser) isGenerated(pos token.Pos) bool {
+	for _, f := range par.Pkg.Syntax {
+		if f.Pos() <= pos && pos < f.End() {
+			return len(f.Comments) > 0 && f.Comments[0].List[0].Text == generatedHeader
+		}
+	}
+	return false
+}
+
+func (par *parser) ParseFile(file *ast.File) {
+	cgIdx := 0
+	scanCmt := func(cur *ast.CommentGroup) *Annotation {
+		if cur == nil {
+			return nil
+		}
+
+		for cgIdx < len(file.Comments) {
+			cg := file.Comments[cgIdx]
+			cgIdx++
+
+			ann, err := extractAnnotation(cg, par.Pkg.Fset)
+			if err != nil {
+				par.Errors = append(par.Errors, err)
+			} else if ann != nil && cg != cur {
+				par.Errors = append(par.Errors, errorf(par.Pkg.Fset, ann.Pos, "Evon annotations apply only to func or interface type declarations"))
+			}
+
+			if cg == cur {
+				return ann
+			}
+		}
+		return nil
+	}
+
+	for _, d := range file.Decls {
+		gd, ok := d.(*ast.GenDecl)
+		if !ok || gd.Tok != token.TYPE {
+			continue
+		}
+
+		grpAnn := scanCmt(gd.Doc)
+		for _, s := range gd.Specs {
+			ts := s.(*ast.TypeSpec)
+
+			ann := scanCmt(ts.Doc)
+			if ann == nil {
+				ann = grpAnn
+			}
+			if ann == nil {
+				continue
+			}
+
+			if !strings.HasSuffix(ts.Name.Name, par.Opts.HandlerSuffix) || len(ts.Name.Name) == len(par.Opts.HandlerSuffix) {
+				par.Errors = append(par.Errors, errorf(par.Pkg.Fset, ts.Name.NamePos, `Handler type "%s" name must have suffix "%s" (and be longer than that)`,
+					ts.Name.Name, par.Opts.HandlerSuffix))
+			}
+
+			if ev, err := par.ExtractEvent(ann, ts); err != nil {
+				par.Errors = append(par.Errors, err)
+			} else if err := par.checkRouteKey(ann, ev); err != nil {
+				par.Errors = append(par.Errors, err)
+			} else if err := par.checkCallResults(ann, ev); err != nil {
+				par.Errors = append(par.Errors, err)
+			} else if err := par.checkReduceResults(ann, ev); err != nil {
+				par.Errors = append(par.Errors, err)
+			} else {
+				par.Decls = append(par.Decls, &Decl{Ann: ann, Event: ev})
+
+				if ann.Flags[annLock] || ann.Flags[annWait] {
+					par.importRecord("sync", "sync", prioInternal)
+				}
+				if ann.Flags[annWait] {
+					par.Imports["sync"].Local = true
+				}
+				if ann.Flags[annBalance] && ann.Flags[annLock] && !ann.Flags[annHash] {
+					par.importRecord("sync/atomic", "atomic", prioInternal)
+				}
+				if ann.Flags[annContext] {
+					par.importRecord("context", "context", prioInternal)
+				}
+				if ann.Flags[annGuard] {
+					par.importRecord("errors", "errors", prioInternal)
+					par.importRecord("runtime", "runtime", prioInternal)
+					par.importRecord("sync", "sync", prioInternal)
+				}
+				if ann.Flags[annLimit] {
+					par.importRecord("runtime", "runtime", prioInternal)
+				}
+				if ann.Flags[annOwner] {
+					par.importRecord("sync", "sync", prioInternal)
+				}
+				if ann.Flags[annHandle] {
+					par.importRecord("sync/atomic", "atomic", prioInternal)
+					if ann.Flags[annSpawn] {
+						par.importRecord("sync", "sync", prioInternal)
+					}
+				}
+				if ann.Flags[annCall] {
+					par.importRecord("errors", "errors", prioInternal)
+				}
+				if ann.Flags[annTopics] {
+					par.importRecord("strings", "strings", prioInternal)
+				}
+			}
+		}
+	}
+}
+
+func (par *parser) ExtractEvent(ann *Annotation, ts *ast.TypeSpec) (*Event, error) {
+	underExpr, underType := par.resolver.Resolve(ts.Type)
+	if underType != nil {
+		return par.importEvent(ann, ts, underType)
+	}
+
+	switch typeImpl := underExpr.(type) {
+	case *ast.FuncType:
+		if err := par.checkFuncFlags(ann); err != nil {
+			return nil, err
+		}
+		return &Event{Name: ts.Name, Funcs: []*Func{par.extractFunc("", typeImpl)}}, nil
+	case *ast.InterfaceType:
+		funcs, partial, ok := par.extractInterface(typeImpl, make(map[string]bool))
+		if !ok {
+			return nil, errorf(par.Pkg.Fset, ts.Name.NamePos, `Cannot resolve type "%s" due to compilation errors`, ts.Name.Name)
+		}
+		return par.interfaceEvent(ann, ts, funcs, partial)
+	case nil:
+		return nil, errorf(par.Pkg.Fset, ts.Name.NamePos, `Cannot resolve type "%s" due to compilation errors`, ts.Name.Name)
+	default:
+		return nil, errorf(par.Pkg.Fset, ann.Pos, "Evon annotations apply only to func or interface type declarations")
+	}
+}
+
+func (par *parser) importEvent(ann *Annotation, ts *ast.TypeSpec, typ types.Type) (*Event, error) {
+	switch typeImpl := typ.(type) {
+	case *types.Signature:
+		if err := par.checkFuncFlags(ann); err != nil {
+			return nil, err
+		}
+		return &Event{Name: ts.Name, Funcs: []*Func{par.importFunc("", typeImpl)}}, nil
+	case *types.Interface:
+		funcs, partial := par.importInterface(typeImpl, make(map[string]bool))
+		return par.interfaceEvent(ann, ts, funcs, partial)
+	default:
+		return nil, errorf(par.Pkg.Fset, ann.Pos, "Evon annotations apply only to func or interface type declarations")
+	}
+}
+
+func (par *parser) checkFuncFlags(ann *Annotation) error {
+	for _, f := range []string{annBase, annDedup, annFuncs, annMsg} {
+		if ann.Flags[f] {
+			return errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" applies only to interface handler types`, f)
+		}
+	}
+	return nil
+}
+
+func (par *parser) interfaceEvent(ann *Annotation, ts *ast.TypeSpec, funcs []*Func, partial bool) (*Event, error) {
+	if len(funcs) == 0 {
+		return nil, errorf(par.Pkg.Fset, ts.Name.NamePos, `Interface type "%s" has no usable methods`, ts.Name.Name)
+	} else if flag := implementingFlag(ann); partial && flag != "" {
+		return nil, errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" requires interface type "%s" to be implementable within current package`,
+			flag, ts.Name.Name)
+	} else if name := reservedMethod(ann, funcs); name != "" {
+		return nil, errorf(par.Pkg.Fset, ts.Name.NamePos, `Interface type "%s" has method "%s" conflicting with generated code`,
+			ts.Name.Name, name)
+	}
+	return &Event{Name: ts.Name, Funcs: funcs, Partial: partial}, nil
+}
+
+func (par *parser) extractFunc(name string, typ *ast.FuncType) *Func {
+	res := &Func{
+		Name: name,
+		Type: typ,
+	}
+
+	for _, g := range typ.Params.List {
+		ast.Walk(&typeVisitor{Parser: par}, g.Type)
+
+		paramType := par.Pkg.TypesInfo.TypeOf(g.Type)
+		if ell, ok := g.Type.(*ast.Ellipsis); ok {
+			if elt := par.Pkg.TypesInfo.TypeOf(ell.Elt); elt != nil {
+				paramType = types.NewSlice(elt)
+			}
+		}
+		res.Params = append(res.Params, paramType)
+		for i := 1; i < len(g.Names); i++ {
+			res.Params = append(res.Params, paramType)
+		}
+	}
+
+	if typ.Results != nil {
+		for _, g := range typ.Results.List {
+			ast.Walk(&typeVisitor{Parser: par}, g.Type)
+
+			resType := par.Pkg.TypesInfo.TypeOf(g.Type)
+			res.Results = append(res.Results, resType)
+			for i := 1; i < len(g.Names); i++ {
+				res.Results = append(res.Results, resType)
+			}
+		}
+	}
+
+	return res
+}
+
+func (par *parser) extractInterface(typ *ast.InterfaceType, mthdNames map[string]bool) ([]*Func, bool, bool) {
+	res := []*Func{}
+	partial := false
+
+	for _, m := range typ.Methods.List {
+		if len(m.Names) > 0 {
+			frec := par.extractFunc(m.Names[0].Name, m.Type.(*ast.FuncType))
+			if !mthdNames[frec.Name] {
+				mthdNames[frec.Name] = true
+				res = append(res, frec)
+			}
+			continue
+		}
+
+		embExpr, embType := par.resolver.Resolve(m.Type)
+		if embIntf, ok := embType.(*types.Interface); ok {
+			embRes, embPartial := par.importInterface(embIntf, mthdNames)
+			res = append(res, embRes...)
+			partial = partial || embPartial
+			continue
+		}
+
+		embIntf, ok := embExpr.(*ast.InterfaceType)
+		if !ok {
+			return nil, false, false
+		}
+		embRes, embPartial, ok := par.extractInterface(embIntf, mthdNames)
+		if !ok {
+			return nil, false, false
+		}
+		res = append(res, embRes...)
+		partial = partial || embPartial
+	}
+
+	return res, partial, true
+}
+
+var reservedMethods = map[string][]string{
+	annRecord: {"Calls", "Reset", "WaitFor", "record"},
+}
+
+// subSuffixes are the suffixes of the subscribing methods generated besides
+// Sub, which the per-method Sub helpers of funcs must not collide with.
+func subSuffixes(ann *Annotation) []string {
+	res := []string{}
+	if ann.Flags[annChan] {
+		res = append(res, "Chan")
+	}
+	if ann.Flags[annOwner] {
+		res = append(res, "Owned")
+	}
+	if ann.Flags[annRoute] {
+		res = append(res, "Key")
+	}
+	if ann.Flags[annContext] {
+		res = append(res, "Context")
+		if ann.Flags[annRoute] {
+			res = append(res, "KeyContext")
+		}
+	}
+	return res
+}
+
+func reservedMethod(ann *Annotation, funcs []*Func) string {
+	for _, f := range funcs {
+		if ann.Flags[annFuncs] {
+			for _, s := range subSuffixes(ann) {
+				if strings.Title(f.Name) == s {
+					return f.Name
+				}
+			}
+		}
+		for flag, names := range reservedMethods {
+			if !ann.Flags[flag] {
+				continue
+			}
+			for _, n := range names {
+				if f.Name == n {
+					return n
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func implementingFlag(ann *Annotation) string {
+	for _, f := range []string{annBase, annChan, annFuncs, annRecord} {
+		if ann.Flags[f] {
+			return f
+		}
+	}
+	return ""
+}
+
+func (par *parser) importRecord(path, name string, prio int) *importRec {
+	return par.Imports.record(path, name, prio)
+}
+
+func (imps importMap) record(path, name string, prio int) *importRec {
+	res, ok := imps[path]
+	if ok {
+		if prio < res.Priority {
+			res.Priority = prio
+		}
+	} else {
+		if prio == prioUser && !strings.Contains(path, ".") {
+			prio = prioStd
+		}
+		res = &importRec{
+			Path:       path,
+			Name:       name,
+			Priority:   prio,
+			PkgIdents:  make(map[*ast.Ident]void),
+			TypeIdents: make(map[*ast.Ident]void),
+		}
+		imps[path] = res
+	}
+	return res
+}
+
+// clone copies the records, sharing the ident sets which are never changed after parsing.
+func (imps importMap) clone() importMap {
+	res := make(importMap)
+	for path, r := range imps {
+		rec := *r
+		res[path] = &rec
+	}
+	return res
+}
+
+type typeVisitor struct {
+	Parser  *parser
+	LastSel *ast.Ident
+}
+
+func (vis *typeVisitor) Visit(node ast.Node) ast.Visitor {
+	switch nodeImpl := node.(type) {
+	case *ast.Ident:
+		if obj, ok := vis.Parser.Pkg.TypesInfo.Uses[nodeImpl]; ok {
+			switch objImpl := obj.(type) {
+			case *types.PkgName:
+				imp := objImpl.Imported()
+				rec := vis.Parser.importRecord(imp.Path(), imp.Name(), prioUser)
+				rec.PkgIdents[nodeImpl] = void{}
+			case *types.TypeName:
+				imp := objImpl.Pkg()
+				if imp != nil && imp != vis.Parser.Pkg.Types && nodeImpl != vis.LastSel {
+					rec := vis.Parser.importRecord(imp.Path(), imp.Name(), prioUser)
+					rec.TypeIdents[nodeImpl] = void{}
+				}
+			}
+		}
+	case *ast.SelectorExpr:
+		vis.LastSel = nodeImpl.Sel
+	}
+
+	return vis
+}
+
+func (par *parser) checkRouteKey(ann *Annotation, ev *Event) error {
+	flag := ""
+	for _, f := range []string{annHash, annRoute} {
+		if ann.Flags[f] {
+			flag = f
+		}
+	}
+	if flag == "" {
+		return nil
+	}
+
+	var keyType types.Type
+	for _, f := range ev.Funcs {
+		typ := routeKeyType(f)
+		if typ == nil || keyType != nil && !types.Identical(typ, keyType) {
+			return errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" requires the first parameters of all handler methods to be of the same comparable type`,
+				flag)
+		}
+		keyType = typ
+	}
+	return nil
+}
+
+func routeKeyType(f *Func) types.Type {
+	params := f.Type.Params.List
+	if len(params) == 0 {
+		return nil
+	}
+	if _, ok := params[0].Type.(*ast.Ellipsis); ok {
+		return nil
+	}
+
+	typ := f.Params[0]
+	if typ == nil || !types.Comparable(typ) {
+		return nil
+	}
+	return typ
+}
+
+func (par *parser) checkCallResults(ann *Annotation, ev *Event) error {
+	if !ann.Flags[annCall] {
+		return nil
+	}
+
+	found := false
+	for _, f := range ev.Funcs {
+		if len(f.Results) == 0 {
+			continue
+		}
+		found = true
+
+		if isBool(f.Results[len(f.Results)-1]) {
+			continue
+		}
+		for _, r := range f.Results {
+			if r == nil || !types.Comparable(r) && !isNilable(r) {
+				return errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" requires handler results to be comparable, or the last one to be bool`,
+					annCall)
+			}
+		}
+	}
+
+	if !found {
+		return errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" requires handler results`, annCall)
+	}
+	return nil
+}
+
+func (par *parser) checkReduceResults(ann *Annotation, ev *Event) error {
+	if !ann.Flags[annReduce] {
+		return nil
+	}
+
+	for _, f := range ev.Funcs {
+		if len(f.Results) > 0 {
+			return nil
+		}
+	}
+	return errorf(par.Pkg.Fset, ann.Pos, `Flag "%s" requires handler results`, annReduce)
+}
+
+func isBool(typ types.Type) bool {
+	return typ != nil && types.Identical(typ, types.Typ[types.Bool])
+}
+
+func isNilable(typ types.Type) bool {
+	switch typ.Underlying().(type) {
+	case *types.Pointer, *types.Interface, *types.Slice, *types.Map, *types.Chan, *types.Signature:
+		return true
+	}
+	return false
+}
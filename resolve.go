@@ -0,0 +1,274 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// typeResolver follows type names to the definitions of their underlying types.
+// Types declared in the target package are resolved to their syntax, while the
+// ones from other packages, whose syntax is never loaded, are resolved to their
+// go/types representations coming from the export data.
+type typeResolver struct {
+	pkg    *packages.Package
+	idents map[types.Object]*ast.Ident
+}
+
+func newTypeResolver(pkg *packages.Package) *typeResolver {
+	return &typeResolver{pkg: pkg}
+}
+
+func (reso *typeResolver) Resolve(typ ast.Expr) (ast.Expr, types.Type) {
+	switch typImpl := typ.(type) {
+	case *ast.Ident:
+		if typImpl.Obj != nil {
+			if ts, ok := typImpl.Obj.Decl.(*ast.TypeSpec); ok {
+				return reso.Resolve(ts.Type)
+			}
+		}
+
+		target, ok := reso.pkg.TypesInfo.Uses[typImpl]
+		if !ok {
+			return nil, nil
+		}
+
+		if target.Pkg() != reso.pkg.Types {
+			if _, ok := target.(*types.TypeName); !ok {
+				return nil, nil
+			}
+			return nil, target.Type().Underlying()
+		}
+
+		nextIdent, ok := reso.identMap()[target]
+		if !ok {
+			return nil, nil
+		}
+
+		return reso.Resolve(nextIdent)
+	case *ast.SelectorExpr:
+		return reso.Resolve(typImpl.Sel)
+	case *ast.ParenExpr:
+		return reso.Resolve(typImpl.X)
+	default:
+		return typ, nil
+	}
+}
+
+func (reso *typeResolver) identMap() map[types.Object]*ast.Ident {
+	if reso.idents != nil {
+		return reso.idents
+	}
+
+	reso.idents = make(map[types.Object]*ast.Ident)
+	for i, o := range reso.pkg.TypesInfo.Defs {
+		if o != nil && o.Parent() == reso.pkg.Types.Scope() {
+			reso.idents[o] = i
+		}
+	}
+	return reso.idents
+}
+
+func (par *parser) importFunc(name string, sig *types.Signature) *Func {
+	res := &Func{
+		Name: name,
+		Type: par.funcTypeExpr(sig),
+	}
+
+	for i := 0; i < sig.Params().Len(); i++ {
+		res.Params = append(res.Params, sig.Params().At(i).Type())
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		res.Results = append(res.Results, sig.Results().At(i).Type())
+	}
+
+	return res
+}
+
+func (par *parser) importInterface(typ *types.Interface, mthdNames map[string]bool) ([]*Func, bool) {
+	mthds := []*types.Func{}
+	for i := 0; i < typ.NumMethods(); i++ {
+		mthds = append(mthds, typ.Method(i))
+	}
+	// go/types keeps methods sorted by name, restore the declaration order
+	sort.SliceStable(mthds, func(i, j int) bool { return mthds[i].Pos() < mthds[j].Pos() })
+
+	res := []*Func{}
+	partial := false
+
+	for _, m := range mthds {
+		if !m.Exported() && m.Pkg() != par.Pkg.Types {
+			partial = true
+			continue
+		}
+		if !mthdNames[m.Name()] {
+			mthdNames[m.Name()] = true
+			res = append(res, par.importFunc(m.Name(), m.Type().(*types.Signature)))
+		}
+	}
+
+	return res, partial
+}
+
+// typeExpr builds the syntax of a go/types type, registering the imports needed
+// by the named types in it the same way typeVisitor does for parsed syntax.
+func (par *parser) typeExpr(typ types.Type) ast.Expr {
+	switch typImpl := typ.(type) {
+	case *types.Basic:
+		if typImpl.Kind() == types.UnsafePointer {
+			id := ast.NewIdent("Pointer")
+			par.importRecord("unsafe", "unsafe", prioUser).TypeIdents[id] = void{}
+			return id
+		}
+		return ast.NewIdent(typImpl.Name())
+	case *types.Named:
+		return par.namedExpr(typImpl.Obj(), typImpl.TypeArgs())
+	case *types.Alias:
+		// Unexported aliases from other packages cannot be referred to, spell out what they stand for
+		if obj := typImpl.Obj(); !obj.Exported() && obj.Pkg() != nil && obj.Pkg() != par.Pkg.Types {
+			return par.typeExpr(typImpl.Rhs())
+		}
+		return par.namedExpr(typImpl.Obj(), typImpl.TypeArgs())
+	case *types.Pointer:
+		return &ast.StarExpr{X: par.typeExpr(typImpl.Elem())}
+	case *types.Slice:
+		return &ast.ArrayType{Elt: par.typeExpr(typImpl.Elem())}
+	case *types.Array:
+		return &ast.ArrayType{
+			Len: &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(typImpl.Len(), 10)},
+			Elt: par.typeExpr(typImpl.Elem()),
+		}
+	case *types.Map:
+		return &ast.MapType{Key: par.typeExpr(typImpl.Key()), Value: par.typeExpr(typImpl.Elem())}
+	case *types.Chan:
+		dir := ast.SEND | ast.RECV
+		switch typImpl.Dir() {
+		case types.SendOnly:
+			dir = ast.SEND
+		case types.RecvOnly:
+			dir = ast.RECV
+		}
+		return &ast.ChanType{Dir: dir, Value: par.typeExpr(typImpl.Elem())}
+	case *types.Signature:
+		return par.funcTypeExpr(typImpl)
+	case *types.Struct:
+		fields := &ast.FieldList{}
+		for i := 0; i < typImpl.NumFields(); i++ {
+			f := typImpl.Field(i)
+			field := &ast.Field{Type: par.typeExpr(f.Type())}
+			if !f.Embedded() {
+				field.Names = []*ast.Ident{ast.NewIdent(f.Name())}
+			}
+			if tag := typImpl.Tag(i); tag != "" {
+				field.Tag = &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(tag)}
+				if strconv.CanBackquote(tag) {
+					field.Tag.Value = "`" + tag + "`"
+				}
+			}
+			fields.List = append(fields.List, field)
+		}
+		return &ast.StructType{Fields: fields}
+	case *types.Interface:
+		mthds := &ast.FieldList{}
+		for i := 0; i < typImpl.NumEmbeddeds(); i++ {
+			mthds.List = append(mthds.List, &ast.Field{Type: par.typeExpr(typImpl.EmbeddedType(i))})
+		}
+		for i := 0; i < typImpl.NumExplicitMethods(); i++ {
+			m := typImpl.ExplicitMethod(i)
+			mthds.List = append(mthds.List, &ast.Field{
+				Names: []*ast.Ident{ast.NewIdent(m.Name())},
+				Type:  par.funcTypeExpr(m.Type().(*types.Signature)),
+			})
+		}
+		return &ast.InterfaceType{Methods: mthds}
+	default:
+		return ast.NewIdent(types.TypeString(typ, nil))
+	}
+}
+
+// namedExpr builds the syntax referring to a defined or alias type, instantiated with args if any.
+func (par *parser) namedExpr(obj *types.TypeName, args *types.TypeList) ast.Expr {
+	id := ast.NewIdent(obj.Name())
+	if imp := obj.Pkg(); imp != nil && imp != par.Pkg.Types {
+		par.importRecord(imp.Path(), imp.Name(), prioUser).TypeIdents[id] = void{}
+	}
+	if args.Len() == 0 {
+		return id
+	}
+
+	indices := []ast.Expr{}
+	for i := 0; i < args.Len(); i++ {
+		indices = append(indices, par.typeExpr(args.At(i)))
+	}
+	if len(indices) == 1 {
+		return &ast.IndexExpr{X: id, Index: indices[0]}
+	}
+	return &ast.IndexListExpr{X: id, Indices: indices}
+}
+
+func (par *parser) funcTypeExpr(sig *types.Signature) *ast.FuncType {
+	res := &ast.FuncType{Params: par.fieldList(sig.Params(), sig.Variadic())}
+	if sig.Results().Len() > 0 {
+		res.Results = par.fieldList(sig.Results(), false)
+	}
+	return res
+}
+
+func (par *parser) fieldList(tuple *types.Tuple, variadic bool) *ast.FieldList {
+	res := &ast.FieldList{}
+	for i := 0; i < tuple.Len(); i++ {
+		v := tuple.At(i)
+		last := variadic && i == tuple.Len()-1
+
+		// Group consecutive names of the same type like "a, b int", as they are usually written
+		if n := len(res.List); n > 0 && v.Name() != "" && !last && types.Identical(v.Type(), tuple.At(i-1).Type()) {
+			res.List[n-1].Names = append(res.List[n-1].Names, ast.NewIdent(v.Name()))
+			continue
+		}
+
+		field := &ast.Field{}
+		if last {
+			field.Type = &ast.Ellipsis{Elt: par.typeExpr(v.Type().(*types.Slice).Elem())}
+		} else {
+			field.Type = par.typeExpr(v.Type())
+		}
+		if v.Name() != "" {
+			field.Names = []*ast.Ident{ast.NewIdent(v.Name())}
+		}
+
+		res.List = append(res.List, field)
+	}
+	return res
+}
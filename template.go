@@ -0,0 +1,1386 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+var localIdents = [...]string{"ev", "em", "s", "ss", "h", "wg", "ch", "rec", "fs", "tp", "topic", "i", "err", "mu", "ok", "got", "sub", "gd", "chain"}
+
+// generatedHeader is the first line of all files generated by evon.
+const generatedHeader = "// Code generated by evon. DO NOT EDIT."
+
+const templateText = generatedHeader + `
+
+package {{.Package}}
+
+{{- if or .Imports .UserImports}}
+	import ({{range .Imports}}{{.Alias}}"{{.Path}}";{{end}}{{if .UserImports}}
+		{{include "imports" .}}
+	{{end}})
+{{- end}}
+
+{{- range .Events}}
+	{{- $ev := index .Dedups "ev"}}
+	{{- $s := index .Dedups "s"}}
+	{{- $h := index .Dedups "h"}}
+	{{- $wg := index .Dedups "wg"}}
+	{{- $em := index .Dedups "em"}}
+	{{- $ss := index .Dedups "ss"}}
+	{{- $i := index .Dedups "i"}}
+	{{- $err := index .Dedups "err"}}
+	{{- $mu := index .Dedups "mu"}}
+	{{- $gd := index .Dedups "gd"}}
+	{{- $chain := index .Dedups "chain"}}
+	{{- $ok := index .Dedups "ok"}}
+	{{- $got := index .Dedups "got"}}
+	{{- $flags := .Flags}}
+
+	{{- $hdlrTyp := printf "%s%s" .Name $.HandlerSuffix}}
+	{{- $evTyp := printf "%s%s" .Name $.EventSuffix}}
+	{{- $slotTyp := printf "__evon_%s_slot__" .Name}}
+	{{- $emitterTyp := printf "__evon_%s_emitter__" .Name}}
+	{{- $callerTyp := printf "__evon_%s_caller__" .Name}}
+	{{- $reducersTyp := printf "%sReducers" .Name}}
+	{{- $subTyp := printf "%sSubscription" .Name}}
+	{{- $cycleErr := prefix "Err" (printf "%sCycle" .Name)}}
+
+	{{- $unsubTyp := ""}}
+	{{- if .Flags.unsub}}{{$unsubTyp = "func()"}}{{end}}
+	{{- if .Flags.handle}}{{$unsubTyp = printf "*%s" $subTyp}}{{end}}
+
+	{{- $compSlot := or .Flags.unsub .Flags.queue}}
+	{{- $dedupUnsub := and .Flags.dedup .Flags.unsub (not .Flags.handle)}}
+	{{- $slotElem := $hdlrTyp}}
+	{{- if $compSlot}}{{$slotElem = $slotTyp}}{{end}}
+
+	{{- $intf := ne (index .Funcs 0).Name ""}}
+
+	{{- $newName := prefix "New" $evTyp}}
+	{{- $newParams := ""}}
+	{{- $newArgs := ""}}
+	{{- if .Flags.queue}}{{$newParams = print $newParams "qsize int, "}}{{$newArgs = print $newArgs "qsize, "}}{{end}}
+	{{- if .Flags.catch}}{{$newParams = print $newParams "catch func(interface{}), "}}{{$newArgs = print $newArgs "catch, "}}{{end}}
+	{{- if .Flags.hash}}{{$newParams = printf "%shash func(key %s) uint64, " $newParams .KeyType}}{{$newArgs = print $newArgs "hash, "}}{{end}}
+	{{- if .Flags.lazy}}{{$newParams = print $newParams "onActive func(), onIdle func(), "}}{{$newArgs = print $newArgs "onActive, onIdle, "}}{{end}}
+	{{- if .Flags.limit}}{{$newParams = print $newParams "limit int, onLimit func(count int, file string, line int) bool, "}}{{$newArgs = print $newArgs "limit, onLimit, "}}{{end}}
+	{{- $reduceTyp := ""}}
+	{{- if .Flags.reduce}}
+		{{- $reduceTyp = $reducersTyp}}
+		{{- if not $intf}}{{$reduceTyp = (index .Funcs 0).ReduceType}}{{end}}
+		{{- $newParams = printf "%sreduce %s, " $newParams $reduceTyp}}{{$newArgs = print $newArgs "reduce, "}}
+	{{- end}}
+
+	{{- if not $.RecordersOnly}}
+
+	// {{$evTyp}} is the **evon** event dispatcher type for {{$hdlrTyp}} handlers.
+	// Flags: {{.FlagsLit}}.
+	type {{$evTyp}} struct {
+		{{if $intf}}Emit {{$emitterTyp}};{{end -}}
+		{{if and $intf .Flags.call}}Call {{$callerTyp}};{{end -}}
+		slots []{{$slotElem}};
+		{{- if .Flags.route}}keyed map[{{.KeyType}}][]{{$slotElem}};{{end}}
+		{{- if .Flags.queue}}qsize int;{{end}}
+		{{- if .Flags.lock}}lock {{$.SyncAlias}}.RWMutex;{{end}}
+		{{- if .Flags.pause}}paused bool;{{end}}
+		{{- if .Flags.catch}}catch func(interface{});{{end}}
+		{{- if .Flags.hash}}hash func(key {{.KeyType}}) uint64;{{else if .Flags.balance}}next uint32;{{end}}
+		{{- if .Flags.reduce}}reduce {{$reduceTyp}};{{end}}
+		{{- if .Flags.lazy}}onActive func(); onIdle func(); active bool;{{if .Flags.lock}}hooks {{$.SyncAlias}}.Mutex;{{else}}hooking bool;{{end}}{{end}}
+		{{- if .Flags.limit}}limit int; onLimit func(count int, file string, line int) bool; limitAccepted, limitReporting bool;{{end}}
+	}
+
+	{{- if .Flags.guard}}
+
+		// {{$cycleErr}} is passed to the panic handler of {{$evTyp}}, when an event is emitted again
+		// through a chain of dispatchers started by itself, and dropped.
+		var {{$cycleErr}} = {{$.ErrorsAlias}}.New("evon: {{$evTyp}} chaining loop detected")
+	{{- end}}
+
+	{{- if and $intf .Flags.reduce}}
+
+		// {{$reducersTyp}} holds the functions combining the results of {{$hdlrTyp}} methods,
+		// each taking the combined results by now followed by the results of one more subscriber.
+		type {{$reducersTyp}} struct {
+			{{- range .Funcs}}{{if .Results}}{{.Name}} {{.ReduceType}};{{end}}{{end}}
+		}
+	{{- end}}
+
+	{{if $compSlot}}
+		type {{$slotTyp}} struct {
+			handler {{$hdlrTyp}};
+			{{- if .Flags.unsub}}index *int;{{end}}
+			{{- if .Flags.queue}}queue chan func();{{end}}
+			{{- if .Flags.handle}}sub *{{$subTyp}};{{end}}
+			{{- if .Flags.owner}}owner interface{};{{end}}
+			{{- if $dedupUnsub}}unsub func();{{end}}
+		}
+	{{end}}
+
+	{{- if .Flags.handle}}
+
+		// {{$subTyp}} is a subscription to {{$evTyp}}, controlling the subscriber only.
+		type {{$subTyp}} struct {
+			calls uint64;
+			skipped uint64;
+			paused uint32;
+			unsub func();
+			{{- if .Flags.queue}}queue chan func();{{end}}
+			{{- if .Flags.spawn}}running {{$.SyncAlias}}.WaitGroup;{{end}}
+			done chan struct{};
+		}
+
+		// Unsub unsubscribes the subscriber from the dispatcher.
+		func (sub *{{$subTyp}}) Unsub() {
+			sub.unsub()
+		}
+
+		// UnsubWait unsubscribes the subscriber like Unsub, and blocks until all its running invocations
+		// have returned{{if .Flags.queue}} and its queued events are handled{{end}}. Calling it from the subscriber itself blocks forever.
+		func (sub *{{$subTyp}}) UnsubWait() {
+			sub.unsub()
+			<-sub.done
+		}
+
+		// Pause stops dispatching events to the subscriber, events emitted meanwhile are skipped.
+		func (sub *{{$subTyp}}) Pause() {
+			{{$.AtomicAlias}}.StoreUint32(&sub.paused, 1)
+		}
+
+		// Resume clears the paused state of the subscriber.
+		func (sub *{{$subTyp}}) Resume() {
+			{{$.AtomicAlias}}.StoreUint32(&sub.paused, 0)
+		}
+
+		// Paused checks if the subscriber is paused.
+		func (sub *{{$subTyp}}) Paused() bool {
+			return {{$.AtomicAlias}}.LoadUint32(&sub.paused) != 0
+		}
+
+		{{- if .Flags.queue}}
+
+		// Pending gets the number of events queued for the subscriber.
+		func (sub *{{$subTyp}}) Pending() int {
+			return len(sub.queue)
+		}
+		{{- end}}
+
+		// Calls gets the number of times the subscriber has been invoked.
+		func (sub *{{$subTyp}}) Calls() uint64 {
+			return {{$.AtomicAlias}}.LoadUint64(&sub.calls)
+		}
+
+		// Skipped gets the number of events skipped while the subscriber is paused.
+		func (sub *{{$subTyp}}) Skipped() uint64 {
+			return {{$.AtomicAlias}}.LoadUint64(&sub.skipped)
+		}
+
+		// Done gets a channel closed after the subscriber is unsubscribed and will never be invoked again.
+		func (sub *{{$subTyp}}) Done() <-chan struct{} {
+			return sub.done
+		}
+
+		{{- if not .Flags.queue}}
+
+		func (sub *{{$subTyp}}) release() {
+			{{- if .Flags.spawn}}
+			go func() {
+				sub.running.Wait()
+				close(sub.done)
+			}()
+			{{- else}}
+			close(sub.done)
+			{{- end}}
+		}
+		{{- end}}
+	{{- end}}
+
+	{{- if $intf}}
+		type {{$emitterTyp}} struct {
+			ev *{{$evTyp}}
+		}
+	{{end}}
+
+	{{- if and $intf .Flags.call}}
+		type {{$callerTyp}} struct {
+			ev *{{$evTyp}}
+		}
+	{{end}}
+
+	{{- $recv := printf "(%s *%s)" $ev $evTyp}}
+	{{- $evLoc := $ev}}
+	{{- if $intf}}
+		{{- $recv = printf "(%s %s)" $em $emitterTyp}}
+		{{- $evLoc = printf "%s.ev" $em}}
+	{{end}}
+
+	
+	{{- $hdlrArg := $s}}
+	{{- if $compSlot}}{{$hdlrArg = printf "%s.handler" $s}}{{end}}
+
+
+	{{- $sub := index .Dedups "sub"}}
+	{{- $subArg := printf "%s.sub" $s}}
+	{{- $subParam := ""}}
+	{{- $subLoc := $subArg}}
+	{{- if and $flags.handle (or $flags.spawn $flags.queue)}}
+		{{- $subParam = printf ", %s *%s" $sub $subTyp}}
+		{{- $subArg = printf ", %s" $subArg}}
+		{{- $subLoc = $sub}}
+	{{- else}}
+		{{- $subArg = ""}}
+	{{- end}}
+
+	{{- range .Funcs}}
+		{{$reduce := and $flags.reduce .Results}}
+		{{$wrapBegin := ""}}
+		{{$wrapEnd := ""}}
+		{{$hdlrParam := ""}}
+		{{$chainParam := ""}}
+		{{$chainArg := ""}}
+		{{if $flags.guard}}
+			{{$chainParam = printf ", %s []__evon_hop__" $chain}}
+			{{$chainArg = printf ", %s.chain" $gd}}
+		{{end}}
+		{{if $flags.spawn}}
+			{{$wrapBegin = printf "go func(%s %s%s%s) {" $h $hdlrTyp $subParam $chainParam}}
+			{{$wrapEnd = printf "}(%s%s%s)" $hdlrArg $subArg $chainArg}}
+			{{$hdlrParam = $h}}
+		{{else if $flags.queue}}
+			{{$wrapBegin = printf "%s.queue <- func(%s %s%s%s) func() { return func() {" $s $h $hdlrTyp $subParam $chainParam}}
+			{{$wrapEnd = printf "}}(%s%s%s)" $hdlrArg $subArg $chainArg}}
+			{{$hdlrParam = $h}}
+		{{else if $flags.catch}}
+			{{$wrapBegin = "func() {"}}
+			{{$wrapEnd = "}()"}}
+		{{end}}
+
+		{{- if $reduce}}
+		// {{or .Name "Emit"}} emits an event to all subscribed handlers, and returns their results combined.
+		func {{$recv}} {{or .Name "Emit"}}{{.ParamsSig}} ({{range .Results}}{{.Name}} {{.Type}}, {{end}}) {
+		{{- else}}
+		// {{or .Name "Emit"}} emits an event to all subscribed handlers.
+		func {{$recv}} {{or .Name "Emit"}}{{.Sig}} {
+		{{- end}}
+			{{- if $flags.lock}}{{$evLoc}}.lock.RLock(); defer {{$evLoc}}.lock.RUnlock();{{end}}
+			{{- if $flags.pause}}if {{$evLoc}}.paused { return };{{end}}
+			{{- if $flags.guard}}
+			{{$gd}}, {{$ok}} := __evon_enter__(__evon_hop__{ {{- $evLoc}}, "{{.Name}}"});
+			if !{{$ok}} {
+				{{$evLoc}}.catch({{$cycleErr}});
+				return
+			};
+			defer {{$gd}}.leave();
+			{{- end}}
+			{{- $key := ""}}
+			{{- if $flags.route}}{{$key = (index .Params 0).Name}}{{end}}
+			{{- if $flags.balance}}
+			{{$i}} := {{$evLoc}}.pick({{if $flags.hash}}{{(index .Params 0).Name}}{{end}});
+			if {{$i}} < 0 { return };
+			{{- end}}
+			{{- if $flags.wait}}{{$wg}} := {{$.SyncAliasLocal}}.WaitGroup{};
+				{{- if $flags.balance}}
+				{{- $wg}}.Add(1);
+				{{- else}}
+				{{- $wg}}.Add(len({{$evLoc}}.slots){{if $key}} + len({{$evLoc}}.keyed[{{$key}}]){{end}});
+				{{- end}}
+			{{- end}}
+			{{- if $reduce}}
+			{{$got}} := false;
+			{{- if $flags.wait}}{{$mu}} := {{$.SyncAliasLocal}}.Mutex{};{{end}}
+			{{- end}}
+			{{- if $flags.balance}}
+			for _, {{$s}} := range {{$evLoc}}.slots[{{$i}}:{{$i}}+1] {
+			{{- else if $key}}
+			for _, {{$ss}} := range [...][]{{$slotElem}}{ {{- $evLoc}}.slots, {{$evLoc}}.keyed[{{$key}}]} {
+			for _, {{$s}} := range {{$ss}} {
+			{{- else}}
+			for _, {{$s}} := range {{$evLoc}}.slots {
+			{{- end}}
+				{{- if $flags.handle}}
+				if {{$.AtomicAlias}}.LoadUint32(&{{$s}}.sub.paused) != 0 {
+					{{$.AtomicAlias}}.AddUint64(&{{$s}}.sub.skipped, 1);
+					{{- if $flags.wait}}{{$wg}}.Done();{{end}}
+					continue
+				};
+				{{- if $flags.spawn}}{{$s}}.sub.running.Add(1);{{end}}
+				{{- end}}
+				{{- $wrapBegin}}
+				{{- if $flags.wait}}defer {{$wg}}.Done();{{end}}
+				{{- if and $flags.handle $flags.spawn}}defer {{$subLoc}}.running.Done();{{end}}
+				{{- if and $flags.guard (or $flags.spawn $flags.queue)}}defer __evon_resume__({{$chain}}).leave();{{end}}
+				{{- if $flags.handle}}{{$.AtomicAlias}}.AddUint64(&{{$subLoc}}.calls, 1);{{end}}
+				{{- if $flags.catch}}
+				defer func() {
+					if e := recover(); e != nil { {{$evLoc}}.catch(e) }
+				}();
+				{{- end}}
+				{{- if $reduce}}
+				{{range $i, $r := .Results}}{{if $i}}, {{end}}{{$r.Local}}{{end}} := {{or $hdlrParam $hdlrArg}}{{if .Name}}.{{.Name}}{{end}}({{.Args}});
+				{{- if $flags.wait}}{{$mu}}.Lock(); defer {{$mu}}.Unlock();{{end}}
+				if {{$got}} {
+					{{range $i, $r := .Results}}{{if $i}}, {{end}}{{$r.Name}}{{end}} = {{$evLoc}}.reduce{{if .Name}}.{{.Name}}{{end}}(
+						{{- range .Results}}{{.Name}}, {{end}}{{range .Results}}{{.Local}}, {{end}})
+				} else {
+					{{range .Results}}{{.Name}}, {{end}}{{$got}} = {{range .Results}}{{.Local}}, {{end}}true
+				};
+				{{- else}}
+				{{or $hdlrParam $hdlrArg}}{{if .Name}}.{{.Name}}{{end}}({{.Args}});
+				{{- end}}
+				{{- $wrapEnd}}
+			};
+			{{- if $key}}};{{end}}
+			{{- if $flags.wait}}{{$wg}}.Wait();{{end}}
+			{{- if .HasResults}}return{{end}}
+		}
+	{{end}}
+
+	{{if .Flags.call}}
+		{{- $errName := prefix "Err" (printf "%sUnhandled" .Name)}}
+		{{- $callRecv := printf "(%s *%s)" $ev $evTyp}}
+		{{- if $intf}}{{$callRecv = printf "(%s %s)" $em $callerTyp}}{{end}}
+
+		// {{$errName}} is returned by calls to {{$evTyp}} that no subscriber handled.
+		var {{$errName}} = {{$.ErrorsAlias}}.New("evon: {{$evTyp}} call unhandled")
+
+		{{range .Funcs}}
+			{{- if .Results}}
+			{{- $key := ""}}
+			{{- if $flags.route}}{{$key = (index .Params 0).Name}}{{end}}
+
+			// {{or .Name "Call"}} invokes subscribed handlers one by one until one of them handles the event,
+			// and returns its results, or {{$errName}} if none did.
+			func {{$callRecv}} {{or .Name "Call"}}{{.ParamsSig}} ({{range .Results}}{{.Name}} {{.Type}}, {{end}}{{$err}} error) {
+				{{- if $flags.lock}}{{$evLoc}}.lock.RLock(); defer {{$evLoc}}.lock.RUnlock();{{end}}
+				{{- if $flags.pause}}if {{$evLoc}}.paused { {{$err}} = {{$errName}}; return };{{end}}
+				{{- if $key}}
+				for _, {{$ss}} := range [...][]{{$slotElem}}{ {{- $evLoc}}.slots, {{$evLoc}}.keyed[{{$key}}]} {
+				for _, {{$s}} := range {{$ss}} {
+				{{- else}}
+				for _, {{$s}} := range {{$evLoc}}.slots {
+				{{- end}}
+					{{- if $flags.handle}}
+					if {{$.AtomicAlias}}.LoadUint32(&{{$s}}.sub.paused) != 0 {
+						{{$.AtomicAlias}}.AddUint64(&{{$s}}.sub.skipped, 1);
+						continue
+					};
+					{{$.AtomicAlias}}.AddUint64(&{{$s}}.sub.calls, 1);
+					{{- end}}
+					{{- if $flags.catch}}
+					func() {
+						defer func() {
+							if e := recover(); e != nil { {{$evLoc}}.catch(e) }
+						}();
+					{{- end}}
+					{{range $i, $r := .Results}}{{if $i}}, {{end}}{{$r.Name}}{{end}} = {{$hdlrArg}}{{if .Name}}.{{.Name}}{{end}}({{.Args}});
+					{{- if $flags.catch}}}();{{end}}
+					if {{.Handled}} { return };
+				};
+				{{- if $key}}};{{end}}
+				return {{range .Results}}{{.Zero}}, {{end}}{{$errName}}
+			}
+			{{- end}}
+		{{end}}
+	{{end}}
+
+	// {{$newName}} creates an **evon** event dispatcher {{$evTyp}}.
+	func {{$newName}}({{$newParams}}) *{{$evTyp}} {
+		ev := &{{$evTyp}}{ {{- if .Flags.route}}keyed: make(map[{{.KeyType}}][]{{$slotElem}}),{{end}}
+			{{- if .Flags.queue}}qsize: qsize,{{end}}{{if .Flags.catch}}catch: catch,{{end}}{{if .Flags.hash}}hash: hash,{{end}}{{if .Flags.reduce}}reduce: reduce,{{end}}
+			{{- if .Flags.lazy}}onActive: onActive, onIdle: onIdle,{{end}}
+			{{- if .Flags.limit}}limit: limit, onLimit: onLimit,{{end -}} };
+		{{- if $intf}}ev.Emit.ev = ev;{{end}}
+		{{- if and $intf .Flags.call}}ev.Call.ev = ev;{{end}}
+		return ev
+	}
+
+	{{- $subModes := list ""}}
+	{{- if and .Flags.route .Flags.owner}}{{$subModes = list "" "key" "owned"}}
+	{{- else if .Flags.route}}{{$subModes = list "" "key"}}
+	{{- else if .Flags.owner}}{{$subModes = list "" "owned"}}{{end}}
+	{{- $keyTyp := .KeyType}}
+
+	{{- range $mode := $subModes}}
+		{{- $keyed := eq $mode "key"}}
+		{{- $owned := eq $mode "owned"}}
+		{{- $slots := printf "%s.slots" $ev}}
+		{{- if $keyed}}
+		{{- $slots = printf "%s.keyed[key]" $ev}}
+
+		// SubKey subscribes a handler to this event dispatcher, for events with the given key only.
+		{{- if $flags.dedup}}
+		// Subscribing a handler already subscribed for the key does nothing.
+		{{- end}}
+		func ({{$ev}} *{{$evTyp}}) SubKey(key {{$keyTyp}}, handler {{$hdlrTyp}}) {{$unsubTyp}} {
+		{{- else if $owned}}
+
+		// SubOwned subscribes a handler to this event dispatcher on behalf of owner,
+		// to be unsubscribed by UnsubOwner together with all others of the same owner.
+		{{- if $flags.dedup}}
+		// Subscribing a handler already subscribed does nothing.
+		{{- end}}
+		func ({{$ev}} *{{$evTyp}}) SubOwned(owner interface{}, handler {{$hdlrTyp}}) {{$unsubTyp}} {
+		{{- else}}
+
+		// Sub subscribes a handler to this event dispatcher.
+		{{- if $flags.dedup}}
+		// Subscribing a handler already subscribed does nothing.
+		{{- end}}
+		func ({{$ev}} *{{$evTyp}}) Sub(handler {{$hdlrTyp}}) {{$unsubTyp}} {
+		{{- end}}
+			{{- if $flags.lazy}}defer {{$ev}}.lifecycle();{{end}}
+			{{- if $flags.lock}}{{$ev}}.lock.Lock(); defer {{$ev}}.lock.Unlock();{{end}}
+			{{- if $flags.dedup}}
+			{{- if $flags.unsub}}
+			if i := {{$ev}}.find({{$slots}}, handler); i >= 0 {
+			{{- else}}
+			if {{$ev}}.find({{$slots}}, handler) >= 0 {
+			{{- end}}
+				{{- if $flags.handle}}
+				return {{$slots}}[i].sub
+				{{- else if $flags.unsub}}
+				return {{$slots}}[i].unsub
+				{{- else}}
+				return
+				{{- end}}
+			};
+			{{- end}}
+			{{- if $flags.limit}}
+			if n := {{$ev}}.count(); n >= {{$ev}}.limit && !{{$ev}}.limitAccepted && !{{$ev}}.limitReporting && {{$ev}}.onLimit != nil {
+				// Subscriptions made while onLimit runs are neither reported nor rejected
+				{{$ev}}.limitReporting = true
+				rejected := func() bool {
+					{{- if $flags.lock}}
+					{{$ev}}.lock.Unlock()
+					{{- end}}
+					defer func() {
+						{{- if $flags.lock}}{{$ev}}.lock.Lock();{{end}}
+						{{$ev}}.limitReporting = false
+					}()
+					return {{$ev}}.overLimit(n)
+				}()
+				if rejected {
+					{{- if $flags.handle}}
+					sub := &{{$subTyp}}{unsub: func() {}, done: make(chan struct{})}
+					close(sub.done)
+					return sub
+					{{- else if $flags.unsub}}
+					return func() {}
+					{{- else}}
+					return
+					{{- end}}
+				}
+				{{$ev}}.limitAccepted = true
+			};
+			{{- end}}
+			{{- if $flags.unsub}}
+			idx := len({{$slots}});
+			{{- end}}
+			{{- if $flags.queue}}q := make(chan func(), {{$ev}}.qsize){{end}}
+			{{- if $flags.handle}}
+			sub := &{{$subTyp}}{ {{- if $flags.queue}}queue: q, {{end}}done: make(chan struct{})};
+			{{- end}}
+			{{$slots}} = append({{$slots}}, {{if $compSlot}}{{$slotTyp}}{
+				handler,{{if $flags.unsub}} &idx,{{end}}{{if $flags.queue}} q,{{end}}{{if $flags.handle}} sub,{{end}}
+				{{- if $flags.owner}}{{if $owned}} owner,{{else}} nil,{{end}}{{end}}
+				{{- if $dedupUnsub}} nil,{{end}}
+			}{{else}}handler{{end}});
+			{{- if $flags.queue}}
+			go func() {
+				for task := range q {
+					task()
+				};
+				{{- if $flags.handle}}close(sub.done){{end}}
+			}();{{end}}
+			{{- if $flags.unsub}}
+			{{if $flags.handle}}sub.unsub ={{else if $dedupUnsub}}unsub :={{else}}return{{end}} func() {
+				{{- if $flags.lazy}}defer {{$ev}}.lifecycle();{{end}}
+				{{- if $flags.lock}}{{$ev}}.lock.Lock(); defer {{$ev}}.lock.Unlock(){{end}}
+				if idx < 0 { return }
+				last := len({{$slots}})-1
+				if last > idx {
+					*({{$slots}}[last].index) = idx
+					{{$slots}}[idx] = {{$slots}}[last]
+				};
+				{{- if $flags.queue}}close(q){{else if $flags.handle}}sub.release(){{end}}
+				{{$slots}} = {{$slots}}[:last]
+				{{- if $keyed}}
+				if last == 0 { delete({{$ev}}.keyed, key) }
+				{{- end}}
+				idx = -1
+			}
+			{{- if $flags.handle}}
+			return sub
+			{{- else if $dedupUnsub}}
+			{{$slots}}[idx].unsub = unsub
+			return unsub
+			{{- end}}
+			{{- end}}
+		}
+
+		{{- if and $flags.context (not $owned)}}
+		{{- $subName := "Sub"}}
+		{{- $subArgs := "handler"}}
+		{{- if $keyed}}{{$subName = "SubKey"}}{{$subArgs = "key, handler"}}{{end}}
+
+		// {{$subName}}Context subscribes a handler to this event dispatcher like {{$subName}},
+		// and unsubscribes it when ctx is done.
+		{{- if $flags.handle}}
+		// To wait for its in-flight calls, call UnsubWait, or wait on Done after ctx is done.
+		{{- end}}
+		func ({{$ev}} *{{$evTyp}}) {{$subName}}Context(ctx {{$.ContextAlias}}.Context, {{if $keyed}}key {{$keyTyp}}, {{end}}handler {{$hdlrTyp}}) {{$unsubTyp}} {
+			{{- if $flags.handle}}
+			sub := {{$ev}}.{{$subName}}({{$subArgs}})
+			go func() {
+				select {
+				case <-ctx.Done():
+					sub.Unsub()
+				case <-sub.Done():
+				}
+			}()
+			return sub
+			{{- else}}
+			unsub := {{$ev}}.{{$subName}}({{$subArgs}})
+			stop := make(chan struct{}, 1)
+			go func() {
+				select {
+				case <-ctx.Done():
+					unsub()
+				case <-stop:
+				}
+			}()
+			return func() {
+				select {
+				case stop <- struct{}{}:
+				default:
+				}
+				unsub()
+			}
+			{{- end}}
+		}
+		{{- end}}
+	{{- end}}
+
+	// Count gets the current number of subscribers on this dispatcher.
+	func ({{$ev}} *{{$evTyp}}) Count() int {
+		{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+		{{- if or .Flags.lazy .Flags.limit}}
+		return {{$ev}}.count()
+	}
+
+	func ({{$ev}} *{{$evTyp}}) count() int {
+		{{- end}}
+		{{- if .Flags.route}}
+		n := len({{$ev}}.slots)
+		for _, slots := range {{$ev}}.keyed {
+			n += len(slots)
+		}
+		return n
+		{{- else}}
+		return len({{$ev}}.slots)
+		{{- end}}
+	}
+
+	{{if .Flags.limit}}
+		// overLimit reports a subscription past the limit to onLimit, with the count of n subscribers
+		// before it, and the first caller outside this file.
+		func ({{$ev}} *{{$evTyp}}) overLimit(n int) bool {
+			_, self, _, _ := {{$.RuntimeAlias}}.Caller(0)
+			for i := 1; ; i++ {
+				_, file, line, ok := {{$.RuntimeAlias}}.Caller(i)
+				if !ok {
+					return {{$ev}}.onLimit(n+1, "", 0)
+				}
+				if file != self {
+					return {{$ev}}.onLimit(n+1, file, line)
+				}
+			}
+		}
+	{{end}}
+
+	{{if .Flags.lazy}}
+		// lifecycle calls onActive or onIdle if Count() has changed from or to 0 since the last call.
+		// They are called one at a time{{if .Flags.lock}} and without the lock held{{end}}, so that they can call into this dispatcher,
+		// with changes made meanwhile caught up afterwards.
+		func ({{$ev}} *{{$evTyp}}) lifecycle() {
+			{{- if .Flags.lock}}
+			for {{$ev}}.stale() && {{$ev}}.hooks.TryLock() {
+				{{$ev}}.lock.Lock()
+				active := {{$ev}}.count() > 0
+				changed := active != {{$ev}}.active
+				{{$ev}}.active = active
+				{{$ev}}.lock.Unlock()
+				func() {
+					defer {{$ev}}.hooks.Unlock()
+					if changed {
+						{{$ev}}.hook(active)
+					}
+				}()
+			}
+			{{- else}}
+			for !{{$ev}}.hooking && ({{$ev}}.count() > 0) != {{$ev}}.active {
+				{{$ev}}.active = !{{$ev}}.active
+				{{$ev}}.hooking = true
+				func() {
+					defer func() { {{- $ev}}.hooking = false }()
+					{{$ev}}.hook({{$ev}}.active)
+				}()
+			}
+			{{- end}}
+		}
+
+		{{- if .Flags.lock}}
+
+		func ({{$ev}} *{{$evTyp}}) stale() bool {
+			{{$ev}}.lock.RLock()
+			defer {{$ev}}.lock.RUnlock()
+			return ({{$ev}}.count() > 0) != {{$ev}}.active
+		}
+		{{- end}}
+
+		func ({{$ev}} *{{$evTyp}}) hook(active bool) {
+			if active && {{$ev}}.onActive != nil {
+				{{$ev}}.onActive()
+			} else if !active && {{$ev}}.onIdle != nil {
+				{{$ev}}.onIdle()
+			}
+		}
+	{{end}}
+
+	{{if .Flags.balance}}
+		func ({{$ev}} *{{$evTyp}}) pick({{if .Flags.hash}}key {{.KeyType}}{{end}}) int {
+			n := len({{$ev}}.slots)
+			if n == 0 {
+				return -1
+			}
+			{{- $paused := printf "%s.LoadUint32(&%s.slots[i].sub.paused) != 0" $.AtomicAlias $ev}}
+			{{- if .Flags.hash}}
+			i := int({{$ev}}.hash(key) % uint64(n))
+			{{- if .Flags.handle}}
+			// Paused subscribers are passed over, unless all of them are paused.
+			for j := 1; j < n && {{$paused}}; j++ {
+				i = (i + 1) % n
+			}
+			{{- end}}
+			{{- else}}
+			{{- $next := printf "int(%s.AddUint32(&%s.next, 1) %% uint32(n))" $.AtomicAlias $ev}}
+			{{- if not .Flags.lock}}{{$next = printf "int(%s.next %% uint32(n))" $ev}}
+			{{$ev}}.next++
+			{{- end}}
+			i := {{$next}}
+			{{- if .Flags.handle}}
+			// The turns of paused subscribers are passed on, unless all of them are paused.
+			for j := 1; j < n && {{$paused}}; j++ {
+				{{- if not .Flags.lock}}{{$ev}}.next++;{{end}}
+				i = {{$next}}
+			}
+			{{- end}}
+			{{- end}}
+			{{- if and .Flags.queue (not .Flags.hash)}}
+			for j := 1; j < n; j++ {
+				if k := (i + j) % n; len({{$ev}}.slots[k].queue) < len({{$ev}}.slots[i].queue)
+					{{- if .Flags.handle}} && {{$.AtomicAlias}}.LoadUint32(&{{$ev}}.slots[k].sub.paused) == 0{{end}} {
+					i = k
+				}
+			}
+			{{- end}}
+			return i
+		}
+	{{end}}
+
+	{{if .Flags.route}}
+		// CountKey gets the current number of subscribers for the given key on this dispatcher,
+		// not including the ones for all keys.
+		func ({{$ev}} *{{$evTyp}}) CountKey(key {{$keyTyp}}) int {
+			{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+			return len({{$ev}}.keyed[key])
+		}
+	{{end}}
+
+	{{if .Flags.unsub}}
+		// Clear unsubscribes all subscribers from this dispatcher.
+		func ({{$ev}} *{{$evTyp}}) Clear() {
+			{{- if .Flags.lazy}}defer {{$ev}}.lifecycle();{{end}}
+			{{- if .Flags.lock}}{{$ev}}.lock.Lock(); defer {{$ev}}.lock.Unlock(){{end}}
+			for _, i := range {{$ev}}.slots {
+				*(i.index) = -1;
+				{{- if .Flags.queue}}close(i.queue){{else if .Flags.handle}}i.sub.release(){{end}}
+			}
+			{{$ev}}.slots = nil
+			{{- if .Flags.route}}
+			for _, slots := range {{$ev}}.keyed {
+				for _, i := range slots {
+					*(i.index) = -1;
+					{{- if .Flags.queue}}close(i.queue){{else if .Flags.handle}}i.sub.release(){{end}}
+				}
+			}
+			{{$ev}}.keyed = make(map[{{.KeyType}}][]{{$slotElem}})
+			{{- end}}
+		}
+	{{end}}
+
+	{{- $byIdent := and $intf .Flags.unsub}}
+
+	{{if $byIdent}}
+		// Unsub unsubscribes the handler from this dispatcher, and reports whether it was subscribed.
+		// Handlers are compared by ==, which panics for non-comparable dynamic types.
+		func ({{$ev}} *{{$evTyp}}) Unsub(handler {{$hdlrTyp}}) bool {
+			{{- if .Flags.lazy}}defer {{$ev}}.lifecycle();{{end}}
+			{{- if .Flags.lock}}{{$ev}}.lock.Lock(); defer {{$ev}}.lock.Unlock(){{end}}
+			return {{$ev}}.unsubIf(func(s {{$slotElem}}) bool {
+				return s.handler == handler
+			})
+		}
+
+		// Has checks if the handler is subscribed to this dispatcher.
+		// Handlers are compared by ==, which panics for non-comparable dynamic types.
+		func ({{$ev}} *{{$evTyp}}) Has(handler {{$hdlrTyp}}) bool {
+			{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+			if {{$ev}}.find({{$ev}}.slots, handler) >= 0 {
+				return true
+			}
+			{{- if .Flags.route}}
+			for _, slots := range {{$ev}}.keyed {
+				if {{$ev}}.find(slots, handler) >= 0 {
+					return true
+				}
+			}
+			{{- end}}
+			return false
+		}
+	{{end}}
+
+	{{if or $byIdent .Flags.dedup}}
+		func ({{$ev}} *{{$evTyp}}) find(slots []{{$slotElem}}, handler {{$hdlrTyp}}) int {
+			for i, s := range slots {
+				if s{{if $compSlot}}.handler{{end}} == handler {
+					return i
+				}
+			}
+			return -1
+		}
+	{{end}}
+
+	{{if .Flags.owner}}
+		// UnsubOwner unsubscribes all subscribers subscribed by SubOwned with the given owner from this dispatcher.
+		func ({{$ev}} *{{$evTyp}}) UnsubOwner(owner interface{}) {
+			if owner == nil {
+				return
+			}
+			{{- if .Flags.lazy}}
+			defer {{$ev}}.lifecycle()
+			{{- end}}
+			{{- if .Flags.lock}}
+			{{$ev}}.lock.Lock()
+			defer {{$ev}}.lock.Unlock()
+			{{- end}}
+			{{$ev}}.unsubIf(func(s {{$slotElem}}) bool {
+				return s.owner == owner
+			})
+		}
+	{{end}}
+
+	{{if or $byIdent .Flags.owner}}
+		func ({{$ev}} *{{$evTyp}}) unsubIf(match func({{$slotElem}}) bool) bool {
+			n := len({{$ev}}.slots)
+			{{$ev}}.slots = {{$ev}}.unsubSlots({{$ev}}.slots, match)
+			found := len({{$ev}}.slots) < n
+			{{- if .Flags.route}}
+			for key, slots := range {{$ev}}.keyed {
+				n = len(slots)
+				if slots = {{$ev}}.unsubSlots(slots, match); len(slots) == 0 {
+					delete({{$ev}}.keyed, key)
+				} else {
+					{{$ev}}.keyed[key] = slots
+				}
+				found = found || len(slots) < n
+			}
+			{{- end}}
+			return found
+		}
+
+		func ({{$ev}} *{{$evTyp}}) unsubSlots(slots []{{$slotElem}}, match func({{$slotElem}}) bool) []{{$slotElem}} {
+			kept := slots[:0]
+			for _, i := range slots {
+				if match(i) {
+					*(i.index) = -1;
+					{{- if .Flags.queue}}close(i.queue){{else if .Flags.handle}}i.sub.release(){{end}}
+					continue
+				}
+				*(i.index) = len(kept)
+				kept = append(kept, i)
+			}
+			for j := len(kept); j < len(slots); j++ {
+				slots[j] = {{$slotElem}}{}
+			}
+			return kept
+		}
+	{{end}}
+
+	{{- end}}
+
+	{{- $name := .Name}}
+	{{- $msgTyp := printf "%s%sMsg" .Name $.EventSuffix}}
+	{{- $argsTyp := printf "%sArgs" .Name}}
+	{{- if $intf}}{{$argsTyp = $msgTyp}}{{end}}
+
+	{{- $argsInMain := or .Flags.chan .Flags.msg}}
+	{{- $withArgs := or $argsInMain (and .Flags.record $.Recorders)}}
+	{{- if $.RecordersOnly}}{{$withArgs = and .Flags.record (not $argsInMain)}}{{end}}
+
+	{{if and $withArgs (not $intf)}}
+		// {{$argsTyp}} holds the arguments of one {{$hdlrTyp}} call.
+		type {{$argsTyp}} struct {
+			{{- range (index .Funcs 0).Params}}{{.Field}} {{.Type}};{{end}}
+		}
+	{{else if $withArgs}}
+		{{- $markMthd := printf "__evon_%s_msg__" .Name}}
+
+		// {{$msgTyp}} is the message type of {{$evTyp}}, implemented by the {{.Name}}*Msg
+		// types, each holding the arguments of one {{$hdlrTyp}} method call.
+		type {{$msgTyp}} interface {
+			{{$markMthd}}()
+		}
+
+		{{range .Funcs}}
+			// {{$name}}{{.Name}}Msg holds the arguments of {{$hdlrTyp}}.{{.Name}}.
+			type {{$name}}{{.Name}}Msg struct {
+				{{- range .Params}}{{.Field}} {{.Type}};{{end}}
+			}
+
+			func ({{$name}}{{.Name}}Msg) {{$markMthd}}() {}
+		{{end}}
+	{{end}}
+
+	{{- if not $.RecordersOnly}}
+
+	{{if .Flags.msg}}
+		// Dispatch emits the event described by a message, to the emitter of the corresponding method.
+		func ({{$ev}} *{{$evTyp}}) Dispatch(msg {{$msgTyp}}) {
+			switch m := msg.(type) {
+			{{- range .Funcs}}
+			case {{$name}}{{.Name}}Msg:
+				{{$ev}}.Emit.{{.Name}}({{range $i, $p := .Params}}{{if $i}}, {{end}}m.{{$p.Field}}{{end}}{{if .Variadic}}...{{end}})
+			{{- end}}
+			}
+		}
+	{{end}}
+
+	{{if .Flags.chan}}
+		{{- $ch := index .Dedups "ch"}}
+		{{- $chanTyp := printf "__evon_%s_chan__" .Name}}
+
+		{{if $intf}}
+			type {{$chanTyp}} chan<- {{$argsTyp}}
+
+			{{range .Funcs}}
+				func ({{$ch}} {{$chanTyp}}) {{.Name}}{{.Sig}} {
+					{{$ch}} <- {{$name}}{{.Name}}Msg{ {{- range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}}{{end -}} };
+					{{- if .HasResults}}return{{end}}
+				}
+			{{end}}
+		{{end}}
+
+		// SubChan subscribes a channel to this event dispatcher, every event is
+		// sent to it as a {{$argsTyp}} value.
+		func ({{$ev}} *{{$evTyp}}) SubChan({{$ch}} chan<- {{$argsTyp}}) {{$unsubTyp}} {
+			{{- if $intf}}
+			return {{$ev}}.Sub({{$chanTyp}}({{$ch}}))
+			{{- else}}
+			{{- with index .Funcs 0}}
+			return {{$ev}}.Sub(func{{.Sig}} {
+				{{$ch}} <- {{$argsTyp}}{ {{- range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}}{{end -}} };
+				{{- if .HasResults}}return{{end}}
+			})
+			{{- end}}
+			{{- end}}
+		}
+
+		// Chan subscribes a newly created channel with the given buffer size to
+		// this event dispatcher, and returns it with the {{if .Flags.handle}}subscription{{else}}unsubscribing function{{end}}.
+		// The channel is never closed.
+		func ({{$ev}} *{{$evTyp}}) Chan(size int) (<-chan {{$argsTyp}}, {{$unsubTyp}}) {
+			ch := make(chan {{$argsTyp}}, size)
+			return ch, {{$ev}}.SubChan(ch)
+		}
+	{{end}}
+
+	{{if .Flags.base}}
+		{{- $baseTyp := printf "%sBase" .Name}}
+
+		// {{$baseTyp}} implements all {{$hdlrTyp}} methods doing nothing, to be embedded
+		// in handlers that implement only a part of them.
+		type {{$baseTyp}} struct{}
+
+		{{range .Funcs}}
+			// {{.Name}} does nothing.
+			func ({{$baseTyp}}) {{.Name}}{{.Sig}} { {{- if .HasResults}}return{{end -}} }
+		{{end}}
+	{{end}}
+
+	{{if .Flags.funcs}}
+		{{- $fs := index .Dedups "fs"}}
+		{{- $funcsTyp := printf "%sFuncs" .Name}}
+		{{- $unsub := .Flags.unsub}}
+
+		// {{$funcsTyp}} implements {{$hdlrTyp}} by calling the func fields of the
+		// corresponding methods, skipping nil ones.
+		type {{$funcsTyp}} struct {
+			{{- range .Funcs}}{{.FuncField}} func{{.Sig}};{{end}}
+		}
+
+		{{range .Funcs}}
+			// {{.Name}} calls {{.FuncField}} if it's not nil.
+			func ({{$fs}} {{$funcsTyp}}) {{.Name}}{{.Sig}} {
+				if {{$fs}}.{{.FuncField}} != nil { {{if .HasResults}}return {{end}}{{$fs}}.{{.FuncField}}({{.Args}}) };
+				{{- if .HasResults}}return{{end}}
+			}
+		{{end}}
+
+		{{range .Funcs}}
+			{{- $subName := printf "Sub%s" (title .Name)}}
+			// {{$subName}} subscribes a func to this event dispatcher, as the handler of {{.Name}} only.
+			func ({{$ev}} *{{$evTyp}}) {{$subName}}(handler func{{.Sig}}) {{$unsubTyp}} {
+				{{if $unsub}}return {{end}}{{$ev}}.Sub(&{{$funcsTyp}}{ {{.FuncField}}: handler })
+			}
+		{{end}}
+	{{end}}
+
+	{{if .Flags.topics}}
+		{{- $tp := index .Dedups "tp"}}
+		{{- $topic := index .Dedups "topic"}}
+		{{- $topicsTyp := printf "%sTopics" .Name}}
+		{{- $nodeTyp := printf "__evon_%s_topic__" .Name}}
+		{{- $topicEmitterTyp := printf "__evon_%s_topic_emitter__" .Name}}
+		{{- $newTopicsName := prefix "New" $topicsTyp}}
+
+		// {{$topicsTyp}} is the **evon** topic dispatcher type for {{$hdlrTyp}} handlers,
+		// with one {{$evTyp}} per subscribed topic pattern.
+		type {{$topicsTyp}} struct {
+			root {{$nodeTyp}}
+			sep string
+			newEvent func() *{{$evTyp}}
+			{{- if .Flags.lock}}
+			lock {{$.SyncAlias}}.RWMutex
+			{{- end}}
+		}
+
+		type {{$nodeTyp}} struct {
+			event *{{$evTyp}}
+			rest *{{$evTyp}}
+			children map[string]*{{$nodeTyp}}
+		}
+
+		{{- if $intf}}
+
+		type {{$topicEmitterTyp}} struct {
+			evs []*{{$evTyp}}
+		}
+		{{- end}}
+
+		// {{$newTopicsName}} creates an **evon** topic dispatcher {{$topicsTyp}}, with sep separating topic levels.
+		func {{$newTopicsName}}(sep string, {{$newParams}}) *{{$topicsTyp}} {
+			return &{{$topicsTyp}}{
+				sep: sep,
+				newEvent: func() *{{$evTyp}} {
+					return {{$newName}}({{$newArgs}})
+				},
+			}
+		}
+
+		// Sub subscribes a handler to this topic dispatcher, for topics matching the pattern.
+		// In patterns, "*" matches exactly one level, and "#" as the last level matches any remaining levels.
+		func ({{$tp}} *{{$topicsTyp}}) Sub(pattern string, handler {{$hdlrTyp}}) {{$unsubTyp}} {
+			{{- if .Flags.lock}}{{$tp}}.lock.Lock(); defer {{$tp}}.lock.Unlock();{{end}}
+			node := &{{$tp}}.root
+			target := &node.event
+			levels := {{$.StringsAlias}}.Split(pattern, {{$tp}}.sep)
+			for i, l := range levels {
+				if l == "#" && i == len(levels)-1 {
+					target = &node.rest
+					break
+				}
+				next := node.children[l]
+				if next == nil {
+					if node.children == nil {
+						node.children = make(map[string]*{{$nodeTyp}})
+					}
+					next = &{{$nodeTyp}}{}
+					node.children[l] = next
+				}
+				node = next
+				target = &node.event
+			}
+			if *target == nil {
+				*target = {{$tp}}.newEvent()
+			}
+			{{- if .Flags.unsub}}
+			{{$ev}} := *target
+			{{- if .Flags.handle}}
+			sub := {{$ev}}.Sub(handler)
+			unsub := sub.unsub
+			sub.unsub = func() {
+				unsub()
+				{{$tp}}.prune(levels, {{$ev}})
+			}
+			return sub
+			{{- else}}
+			unsub := {{$ev}}.Sub(handler)
+			return func() {
+				unsub()
+				{{$tp}}.prune(levels, {{$ev}})
+			}
+			{{- end}}
+			{{- else}}
+			(*target).Sub(handler)
+			{{- end}}
+		}
+
+		{{- if .Flags.unsub}}
+
+		// prune removes the dispatcher of the pattern split into levels if it's {{$ev}} and has no subscribers
+		// left, and then the nodes left empty, so that patterns used once don't pile up.
+		func ({{$tp}} *{{$topicsTyp}}) prune(levels []string, {{$ev}} *{{$evTyp}}) {
+			{{- if .Flags.lock}}{{$tp}}.lock.Lock(); defer {{$tp}}.lock.Unlock();{{end}}
+			nodes := []*{{$nodeTyp}}{&{{$tp}}.root}
+			rest := len(levels) > 0 && levels[len(levels)-1] == "#"
+			if rest {
+				levels = levels[:len(levels)-1]
+			}
+			for _, l := range levels {
+				next := nodes[len(nodes)-1].children[l]
+				if next == nil {
+					return
+				}
+				nodes = append(nodes, next)
+			}
+
+			node := nodes[len(nodes)-1]
+			target := &node.event
+			if rest {
+				target = &node.rest
+			}
+			if *target != {{$ev}} || {{$ev}}.Count() > 0 {
+				return
+			}
+			*target = nil
+
+			for i := len(nodes) - 1; i > 0; i-- {
+				node = nodes[i]
+				if node.event != nil || node.rest != nil || len(node.children) > 0 {
+					break
+				}
+				delete(nodes[i-1].children, levels[i-1])
+			}
+		}
+		{{- end}}
+
+		func ({{$tp}} *{{$topicsTyp}}) match(topic string) []*{{$evTyp}} {
+			{{- if .Flags.lock}}{{$tp}}.lock.RLock(); defer {{$tp}}.lock.RUnlock();{{end}}
+			return {{$tp}}.root.match({{$.StringsAlias}}.Split(topic, {{$tp}}.sep), nil)
+		}
+
+		func (node *{{$nodeTyp}}) match(levels []string, res []*{{$evTyp}}) []*{{$evTyp}} {
+			if node.rest != nil {
+				res = append(res, node.rest)
+			}
+			if len(levels) == 0 {
+				if node.event != nil {
+					res = append(res, node.event)
+				}
+				return res
+			}
+			if next := node.children[levels[0]]; next != nil {
+				res = next.match(levels[1:], res)
+			}
+			if next := node.children["*"]; next != nil && levels[0] != "*" {
+				res = next.match(levels[1:], res)
+			}
+			return res
+		}
+
+		{{if $intf}}
+			// Emit gets the emitter of the given topic, whose methods emit events to all
+			// handlers subscribed with patterns matching the topic.
+			func ({{$tp}} *{{$topicsTyp}}) Emit(topic string) {{$topicEmitterTyp}} {
+				return {{$topicEmitterTyp}}{ {{- $tp}}.match(topic)}
+			}
+
+			{{range .Funcs}}
+				{{- if and $flags.reduce .Results}}
+				// {{.Name}} emits an event to all handlers subscribed with patterns matching the topic,
+				// and returns their results combined.
+				func ({{$em}} {{$topicEmitterTyp}}) {{.Name}}{{.ParamsSig}} ({{range .Results}}{{.Name}} {{.Type}}, {{end}}) {
+					{{- template "reduceTopics" (list . $got $ev (printf "%s.evs" $em) (printf ".Emit.%s" .Name) (printf ".reduce.%s" .Name))}}
+				}
+				{{- else}}
+				// {{.Name}} emits an event to all handlers subscribed with patterns matching the topic.
+				func ({{$em}} {{$topicEmitterTyp}}) {{.Name}}{{.Sig}} {
+					for _, {{$ev}} := range {{$em}}.evs {
+						{{$ev}}.Emit.{{.Name}}({{.Args}})
+					};
+					{{- if .HasResults}}return{{end}}
+				}
+				{{- end}}
+			{{end}}
+		{{else}}
+			{{- with index .Funcs 0}}
+			{{- if $flags.reduce}}
+			// Emit emits an event to all handlers subscribed with patterns matching the topic,
+			// and returns their results combined.
+			func ({{$tp}} *{{$topicsTyp}}) Emit({{$topic}} string, {{slice .ParamsSig 1}} ({{range .Results}}{{.Name}} {{.Type}}, {{end}}) {
+				{{- template "reduceTopics" (list . $got $ev (printf "%s.match(%s)" $tp $topic) ".Emit" ".reduce")}}
+			}
+			{{- else}}
+			// Emit emits an event to all handlers subscribed with patterns matching the topic.
+			func ({{$tp}} *{{$topicsTyp}}) Emit({{$topic}} string, {{slice .Sig 1}} {
+				for _, {{$ev}} := range {{$tp}}.match({{$topic}}) {
+					{{$ev}}.Emit({{.Args}})
+				};
+				{{- if .HasResults}}return{{end}}
+			}
+			{{- end}}
+			{{- end}}
+		{{end}}
+	{{end}}
+
+	{{if .Flags.pause}}
+		// Resume clears the paused state of this dispatcher.
+		func ({{$ev}} *{{$evTyp}}) Resume() {
+			{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+			{{$ev}}.paused = false
+		}
+
+		// Pause stops this dispatcher from dispatching events.
+		func ({{$ev}} *{{$evTyp}}) Pause() {
+			{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+			{{$ev}}.paused = true
+		}
+
+		// Paused checks if this dispatcher is paused.
+		func ({{$ev}} *{{$evTyp}}) Paused() bool {
+			{{- if .Flags.lock}}{{$ev}}.lock.RLock(); defer {{$ev}}.lock.RUnlock(){{end}}
+			return {{$ev}}.paused
+		}
+	{{end}}
+
+	{{- end}}
+
+	{{if and .Flags.record $.Recorders}}
+		{{- $rec := index .Dedups "rec"}}
+		{{- $recTyp := printf "%sRecorder" .Name}}
+		{{- $callTyp := printf "%sCall" .Name}}
+
+		// {{$recTyp}} is the **evon** recorder type for {{$hdlrTyp}} handlers, which
+		// records every call to it.
+		type {{$recTyp}} struct {
+			lock {{$.SyncAlias}}.Mutex
+			calls []{{$callTyp}}
+			notify chan struct{}
+		}
+
+		// {{$callTyp}} is a call recorded by {{$recTyp}}.
+		type {{$callTyp}} struct {
+			Time {{$.TimeAlias}}.Time
+			{{if $intf}}Msg{{else}}Args{{end}} {{$argsTyp}}
+		}
+
+		{{- $newName := prefix "New" $recTyp}}
+
+		// {{$newName}} creates an **evon** recorder {{$recTyp}}.
+		func {{$newName}}() *{{$recTyp}} {
+			return &{{$recTyp}}{notify: make(chan struct{})}
+		}
+
+		{{range .Funcs}}
+			{{- if .Name}}
+			// {{.Name}} records a call to {{$hdlrTyp}}.{{.Name}}.
+			{{- else}}
+			// Handle records a call to {{$hdlrTyp}}, subscribe it like "Sub(recorder.Handle)".
+			{{- end}}
+			func ({{$rec}} *{{$recTyp}}) {{or .Name "Handle"}}{{.Sig}} {
+				{{$rec}}.record({{if .Name}}{{$name}}{{.Name}}Msg{{else}}{{$argsTyp}}{{end}}{ {{- range $i, $p := .Params}}{{if $i}}, {{end}}{{$p.Name}}{{end -}} });
+				{{- if .HasResults}}return{{end}}
+			}
+		{{end}}
+
+		func (rec *{{$recTyp}}) record(args {{$argsTyp}}) {
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			rec.calls = append(rec.calls, {{$callTyp}}{ {{$.TimeAlias}}.Now(), args })
+			close(rec.notify)
+			rec.notify = make(chan struct{})
+		}
+
+		// Calls gets all calls recorded by now.
+		func (rec *{{$recTyp}}) Calls() []{{$callTyp}} {
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			return append([]{{$callTyp}}(nil), rec.calls...)
+		}
+
+		// WaitFor waits until at least n calls are recorded, and reports false if timed out.
+		func (rec *{{$recTyp}}) WaitFor(n int, timeout {{$.TimeAlias}}.Duration) bool {
+			timer := {{$.TimeAlias}}.NewTimer(timeout)
+			defer timer.Stop()
+			for {
+				rec.lock.Lock()
+				count, notify := len(rec.calls), rec.notify
+				rec.lock.Unlock()
+
+				if count >= n {
+					return true
+				}
+				select {
+				case <-notify:
+				case <-timer.C:
+					return false
+				}
+			}
+		}
+
+		// Reset discards all calls recorded by now.
+		func (rec *{{$recTyp}}) Reset() {
+			rec.lock.Lock()
+			defer rec.lock.Unlock()
+			rec.calls = nil
+		}
+	{{end}}
+
+	{{- if and .Template (not $.RecordersOnly)}}
+
+		{{include .Template .}}
+	{{- end}}
+{{end}}
+
+{{- if .Guard}}
+
+	type __evon_hop__ struct {
+		ev interface{}
+		name string
+	}
+
+	type __evon_guard__ struct {
+		id uint64
+		prev, chain []__evon_hop__
+	}
+
+	// __evon_chains__ holds the hops passed through by the emissions running in each goroutine, by goroutine id.
+	var __evon_chains__ {{.SyncAlias}}.Map
+
+	func __evon_goid__() uint64 {
+		var buf [64]byte
+		n := {{.RuntimeAlias}}.Stack(buf[:], false)
+		id := uint64(0)
+		for _, c := range buf[len("goroutine "):n] {
+			if c < '0' || c > '9' {
+				break
+			}
+			id = id*10 + uint64(c-'0')
+		}
+		return id
+	}
+
+	// __evon_enter__ appends hop to the chain of the current goroutine, or reports false if it's already there.
+	func __evon_enter__(hop __evon_hop__) (__evon_guard__, bool) {
+		gd := __evon_guard__{id: __evon_goid__()}
+		if chain, ok := __evon_chains__.Load(gd.id); ok {
+			gd.prev = chain.([]__evon_hop__)
+		}
+		for _, h := range gd.prev {
+			if h == hop {
+				return gd, false
+			}
+		}
+		gd.chain = append(gd.prev[:len(gd.prev):len(gd.prev)], hop)
+		__evon_chains__.Store(gd.id, gd.chain)
+		return gd, true
+	}
+
+	// __evon_resume__ continues a chain passed by spawn or queue in the goroutine running the handler,
+	// which has no chain of its own.
+	func __evon_resume__(chain []__evon_hop__) __evon_guard__ {
+		gd := __evon_guard__{id: __evon_goid__(), chain: chain}
+		__evon_chains__.Store(gd.id, chain)
+		return gd
+	}
+
+	func (gd __evon_guard__) leave() {
+		if len(gd.prev) == 0 {
+			__evon_chains__.Delete(gd.id)
+		} else {
+			__evon_chains__.Store(gd.id, gd.prev)
+		}
+	}
+{{- end}}
+
+{{- if .Group}}
+
+	// {{.Group}} collects unsubscribing functions from **evon** event dispatchers, to call them together.
+	type {{.Group}} struct {
+		lock {{.SyncAlias}}.Mutex
+		unsubs []func()
+	}
+
+	// Add adds unsubscribing functions to this group.
+	func (g *{{.Group}}) Add(unsubs ...func()) {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		g.unsubs = append(g.unsubs, unsubs...)
+	}
+
+	// Release calls all unsubscribing functions added by now in reverse order, and empties this group.
+	func (g *{{.Group}}) Release() {
+		g.lock.Lock()
+		unsubs := g.unsubs
+		g.unsubs = nil
+		g.lock.Unlock()
+
+		for i := len(unsubs) - 1; i >= 0; i-- {
+			unsubs[i]()
+		}
+	}
+{{- end}}
+
+{{- define "reduceTopics"}}
+	{{- $f := index . 0}}
+	{{- $got := index . 1}}
+	{{- $ev := index . 2}}
+	{{$got}} := false
+	for _, {{$ev}} := range {{index . 3}} {
+		if {{$ev}}.Count() == 0 {
+			continue
+		}
+		{{range $i, $r := $f.Results}}{{if $i}}, {{end}}{{$r.Local}}{{end}} := {{$ev}}{{index . 4}}({{$f.Args}})
+		if {{$got}} {
+			{{range $i, $r := $f.Results}}{{if $i}}, {{end}}{{$r.Name}}{{end}} = {{$ev}}{{index . 5}}(
+				{{- range $f.Results}}{{.Name}}, {{end}}{{range $f.Results}}{{.Local}}, {{end}})
+		} else {
+			{{range $f.Results}}{{.Name}}, {{end}}{{$got}} = {{range $f.Results}}{{.Local}}, {{end}}true
+		}
+	}
+	return
+{{- end}}`
@@ -0,0 +1,115 @@
+// Copyright (c) 2020, lych77
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice, this
+//    list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from
+//    this software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+// DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+// FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+// DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+// SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+// CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+// OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+// OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+
+package evon
+
+import (
+	"go/ast"
+	"reflect"
+	"strconv"
+)
+
+type void = struct{}
+
+type dedupSet map[string]bool
+
+func newDedupSet(preset ...string) dedupSet {
+	set := make(dedupSet)
+	for _, p := range preset {
+		set[p] = true
+	}
+	return set
+}
+
+func (set dedupSet) Resolve(name string) string {
+	alias := name
+	for i := 1; set[alias]; i++ {
+		alias = name + strconv.Itoa(i)
+	}
+	set[alias] = true
+	return alias
+}
+
+func (set dedupSet) Merge(other dedupSet) {
+	for n := range other {
+		set[n] = true
+	}
+}
+
+// cloneExpr deep copies a syntax tree, replacing the names of the idents found
+// in names.
+func cloneExpr(expr ast.Expr, names map[*ast.Ident]string) ast.Expr {
+	if expr == nil {
+		return nil
+	}
+	return cloneValue(reflect.ValueOf(expr), names).Interface().(ast.Expr)
+}
+
+func cloneValue(v reflect.Value, names map[*ast.Ident]string) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		// Obj links back to declarations, which are not part of the expression
+		if id, ok := v.Interface().(*ast.Ident); ok {
+			res := &ast.Ident{NamePos: id.NamePos, Name: id.Name}
+			if name, ok := names[id]; ok {
+				res.Name = name
+			}
+			return reflect.ValueOf(res)
+		}
+		res := reflect.New(v.Type().Elem())
+		res.Elem().Set(cloneValue(v.Elem(), names))
+		return res
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.New(v.Type()).Elem()
+		res.Set(cloneValue(v.Elem(), names))
+		return res
+	case reflect.Struct:
+		res := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			res.Field(i).Set(cloneValue(v.Field(i), names))
+		}
+		return res
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(cloneValue(v.Index(i), names))
+		}
+		return res
+	default:
+		return v
+	}
+}